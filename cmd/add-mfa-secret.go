@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/spf13/cobra"
+)
+
+// addMfaSecretCmd represents the add-mfa-secret command
+var addMfaSecretCmd = &cobra.Command{
+	Use:   "add-mfa-secret <mfa_serial>",
+	Short: "add the secret of a virtual MFA device, to compute the codes of profiles with mfa_serial",
+	RunE:  addMfaSecret,
+}
+
+func init() {
+	RootCmd.AddCommand(addMfaSecretCmd)
+}
+
+func addMfaSecret(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return ErrTooFewArguments
+	}
+	if len(args) > 1 {
+		return ErrTooManyArguments
+	}
+	serial := args[0]
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("command", "add-mfa-secret"),
+		})
+	}
+
+	secret, err := lib.Prompt("MFA secret (base32) for "+serial, true)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+
+	// check the secret, and let the user compare the code with their device
+	code, err := lib.TOTPCode(secret, time.Now())
+	if err != nil {
+		return err
+	}
+
+	item := keyring.Item{
+		Key:                         lib.MFASecretKeyringKey(serial),
+		Data:                        []byte(secret),
+		Label:                       "aws mfa secret",
+		KeychainNotTrustApplication: false,
+	}
+	if err := kr.Set(item); err != nil {
+		log.Debugf("Failed to add MFA secret to keyring: %s", err)
+		return ErrFailedToSetCredentials
+	}
+
+	log.Infof("Added MFA secret for %s, whose current code is %s", serial, code)
+	return nil
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/sessioncache"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "cache lists and removes the cached sessions",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the cached sessions of all session caches",
+	RunE:  cacheListRun,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [profile]",
+	Short: "remove the cached sessions of the profile, or all of them",
+	RunE:  cacheClearRun,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "remove the expired sessions",
+	RunE:  cachePruneRun,
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+}
+
+// namedSessionCache is a session cache and its --session-cache name
+type namedSessionCache struct {
+	name  string
+	store lib.SessionCacheInterface
+}
+
+// openSessionCaches opens the keyring and returns all the session caches, as
+// sessions may be in any of them
+func openSessionCaches(command string) ([]namedSessionCache, error) {
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return nil, err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("command", command),
+		})
+	}
+
+	var caches []namedSessionCache
+	for _, name := range []string{lib.SessionCacheItemPerSession, lib.SessionCacheSingleItem, lib.SessionCacheFile} {
+		store, err := lib.NewSessionCache(name, kr)
+		if err != nil {
+			return nil, err
+		}
+		caches = append(caches, namedSessionCache{name, store})
+	}
+	return caches, nil
+}
+
+// listSessionCache lists the entries of cache, or warns that it can't
+func listSessionCache(cache namedSessionCache) []sessioncache.Entry {
+	entries, err := cache.store.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not list the %s session cache: %s\n", cache.name, err)
+	}
+	return entries
+}
+
+func sessionExpired(session sessioncache.Session) bool {
+	return session.Expiration == nil || session.Expiration.Before(time.Now())
+}
+
+func cacheListRun(cmd *cobra.Command, args []string) error {
+	caches, err := openSessionCaches("cache list")
+	if err != nil {
+		return err
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 2, '\t', 0)
+	fmt.Fprintln(w, "KEY\tPROFILE\tSESSION_NAME\tEXPIRES\tSTORE\t")
+	for _, cache := range caches {
+		for _, entry := range listSessionCache(cache) {
+			expires := "-"
+			if entry.Expiration != nil {
+				expires = entry.Expiration.Local().Format(time.RFC3339)
+			}
+			if sessionExpired(entry.Session) {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Key, entry.Profile, entry.Name, expires, cache.name)
+		}
+	}
+	w.Flush()
+
+	return nil
+}
+
+// deleteSessions deletes the sessions of caches that match, and reports how
+// many it deleted
+func deleteSessions(caches []namedSessionCache, match func(sessioncache.Entry) bool) {
+	deleted := 0
+	for _, cache := range caches {
+		for _, entry := range listSessionCache(cache) {
+			if !match(entry) {
+				continue
+			}
+			if err := cache.store.Delete(sessioncache.RawKey(entry.Key)); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: could not delete %s from the %s session cache: %s\n", entry.Key, cache.name, err)
+				continue
+			}
+			deleted++
+		}
+	}
+	log.Infof("Deleted %d sessions", deleted)
+}
+
+func cacheClearRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return ErrTooManyArguments
+	}
+
+	profiles, err := listProfiles()
+	if err != nil {
+		return err
+	}
+
+	caches, err := openSessionCaches("cache clear")
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		// the file is removed whole, as it may not be decryptable
+		for _, cache := range caches {
+			fileStore, ok := cache.store.(*sessioncache.FileStore)
+			if !ok {
+				continue
+			}
+			if err := fileStore.Clear(); err == nil {
+				log.Infof("Removed the %s session cache", cache.name)
+			} else if !xerrors.Is(err, keyring.ErrKeyNotFound) {
+				fmt.Fprintf(os.Stderr, "warning: could not remove the %s session cache: %s\n", cache.name, err)
+			}
+		}
+	}
+
+	deleteSessions(caches, func(entry sessioncache.Entry) bool {
+		return len(args) == 0 || profiles.SessionOfProfile(entry, args[0])
+	})
+	return nil
+}
+
+func cachePruneRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+
+	caches, err := openSessionCaches("cache prune")
+	if err != nil {
+		return err
+	}
+
+	deleteSessions(caches, func(entry sessioncache.Entry) bool {
+		return sessionExpired(entry.Session)
+	})
+	return nil
+}
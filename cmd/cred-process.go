@@ -37,6 +37,9 @@ func init() {
 	RootCmd.AddCommand(credProcessCmd)
 	credProcessCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	credProcessCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	credProcessCmd.Flags().StringVar(&sessionPolicy, "policy", "", "Session policy (JSON or a file) to scope the credentials down with; overrides session_policy in profile")
+	credProcessCmd.Flags().StringSliceVar(&policyARNs, "policy-arn", nil, "Managed policy to scope the credentials down with, may be repeated; overrides policy_arns in profile")
+	credProcessCmd.Flags().StringVar(&samlFile, "saml-file", "", "Assume the role with the SAML response in this file (- for stdin) instead of getting one from Okta")
 	credProcessCmd.Flags().BoolVarP(&pretty, "pretty", "p", false, "Pretty print display")
 }
 
@@ -64,22 +67,24 @@ func credProcessRun(cmd *cobra.Command, args []string) error {
 
 	// check profile for both session durations if not explicitly set
 	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		if err := updateAssumeRoleTTLFromConfigProfile(profiles, profile); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse duration_seconds or assume_role_ttl from profile config")
 		}
 	}
 
 	if !cmd.Flags().Lookup("session-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+		if err := updateSessionTTLFromConfigProfile(profiles, profile); err != nil {
 			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
 		}
 	}
 
 	opts := lib.ProviderOptions{
-		MFAConfig:          mfaConfig,
-		Profiles:           profiles,
-		SessionDuration:    sessionTTL,
-		AssumeRoleDuration: assumeRoleTTL,
+		MFAConfig:                mfaConfig,
+		Profiles:                 profiles,
+		SessionDuration:          sessionTTL,
+		SessionDurationFromSAML:  sessionTTLFromSAML,
+		SessionDurationDefaulted: sessionTTLDefaulted(cmd, profiles, profile),
+		AssumeRoleDuration:       assumeRoleTTL,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -104,7 +109,18 @@ func credProcessRun(cmd *cobra.Command, args []string) error {
 		})
 	}
 
+	if samlFile != "" {
+		if opts.SAMLAssertion, err = readSAMLFile(samlFile); err != nil {
+			return err
+		}
+	}
+
+	if opts.SessionPolicy, err = sessionPolicyFromFlags(); err != nil {
+		return err
+	}
+
 	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	opts.SessionCacheStore = flagSessionCache
 
 	p, err := lib.NewProvider(kr, profile, opts)
 	if err != nil {
@@ -26,6 +26,9 @@ func init() {
 	RootCmd.AddCommand(envCmd)
 	envCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	envCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	envCmd.Flags().StringVar(&sessionPolicy, "policy", "", "Session policy (JSON or a file) to scope the credentials down with; overrides session_policy in profile")
+	envCmd.Flags().StringSliceVar(&policyARNs, "policy-arn", nil, "Managed policy to scope the credentials down with, may be repeated; overrides policy_arns in profile")
+	envCmd.Flags().StringVar(&samlFile, "saml-file", "", "Assume the role with the SAML response in this file (- for stdin) instead of getting one from Okta")
 }
 
 func envRun(cmd *cobra.Command, args []string) error {
@@ -52,22 +55,24 @@ func envRun(cmd *cobra.Command, args []string) error {
 
 	// check profile for both session durations if not explicitly set
 	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		if err := updateAssumeRoleTTLFromConfigProfile(profiles, profile); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse duration_seconds or assume_role_ttl from profile config")
 		}
 	}
 
 	if !cmd.Flags().Lookup("session-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+		if err := updateSessionTTLFromConfigProfile(profiles, profile); err != nil {
 			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
 		}
 	}
 
 	opts := lib.ProviderOptions{
-		MFAConfig:          mfaConfig,
-		Profiles:           profiles,
-		SessionDuration:    sessionTTL,
-		AssumeRoleDuration: assumeRoleTTL,
+		MFAConfig:                mfaConfig,
+		Profiles:                 profiles,
+		SessionDuration:          sessionTTL,
+		SessionDurationFromSAML:  sessionTTLFromSAML,
+		SessionDurationDefaulted: sessionTTLDefaulted(cmd, profiles, profile),
+		AssumeRoleDuration:       assumeRoleTTL,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -92,7 +97,18 @@ func envRun(cmd *cobra.Command, args []string) error {
 		})
 	}
 
+	if samlFile != "" {
+		if opts.SAMLAssertion, err = readSAMLFile(samlFile); err != nil {
+			return err
+		}
+	}
+
+	if opts.SessionPolicy, err = sessionPolicyFromFlags(); err != nil {
+		return err
+	}
+
 	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	opts.SessionCacheStore = flagSessionCache
 
 	p, err := lib.NewProvider(kr, profile, opts)
 	if err != nil {
@@ -128,5 +144,9 @@ func envRun(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("export AWS_OKTA_SESSION_EXPIRATION=%d\n", p.GetExpiration().Unix())
 
+	for _, v := range sessionAttributeVars(p.SessionAttributes()) {
+		fmt.Printf("export %s=%s\n", v[0], shellescape.Quote(v[1]))
+	}
+
 	return nil
 }
@@ -2,12 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 
 	log "github.com/sirupsen/logrus"
 
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -15,6 +18,7 @@ import (
 	"github.com/99designs/keyring"
 	analytics "github.com/segmentio/analytics-go"
 	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/lib/saml"
 	"github.com/spf13/cobra"
 )
 
@@ -22,6 +26,11 @@ var (
 	sessionTTL    time.Duration
 	assumeRoleTTL time.Duration
 	assumeRoleARN string
+	// set by `session_ttl = saml`
+	sessionTTLFromSAML bool
+	samlFile           string
+	sessionPolicy      string
+	policyARNs         []string
 )
 
 func mustListProfiles() lib.Profiles {
@@ -45,7 +54,10 @@ func init() {
 	RootCmd.AddCommand(execCmd)
 	execCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	execCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
-	execCmd.Flags().StringVarP(&assumeRoleARN, "assume-role-arn", "r", "", "Role arn to assume, overrides arn in profile")
+	execCmd.Flags().StringVar(&sessionPolicy, "policy", "", "Session policy (JSON or a file) to scope the credentials down with; overrides session_policy in profile")
+	execCmd.Flags().StringSliceVar(&policyARNs, "policy-arn", nil, "Managed policy to scope the credentials down with, may be repeated; overrides policy_arns in profile")
+	execCmd.Flags().StringVar(&samlFile, "saml-file", "", "Assume the role with the SAML response in this file (- for stdin) instead of getting one from Okta")
+	execCmd.Flags().StringVarP(&assumeRoleARN, "assume-role-arn", "r", "", "Role to assume, as an ARN, role name, account:role or glob; overrides role_arn in profile")
 }
 
 func loadDurationFlagFromEnv(cmd *cobra.Command, flagName string, envVar string, val *time.Duration) error {
@@ -98,6 +110,95 @@ func updateDurationFromConfigProfile(profiles lib.Profiles, profile string, key
 	return nil
 }
 
+// updateAssumeRoleTTLFromConfigProfile sets assumeRoleTTL from the profile's
+// own `duration_seconds`, as the AWS CLI names it, or else its
+// assume_role_ttl
+func updateAssumeRoleTTLFromConfigProfile(profiles lib.Profiles, profile string) error {
+	if seconds := profiles[profile]["duration_seconds"]; seconds != "" {
+		dur, err := strconv.Atoi(seconds)
+		if err != nil {
+			return err
+		}
+		assumeRoleTTL = time.Duration(dur) * time.Second
+		return nil
+	}
+	return updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL)
+}
+
+// updateSessionTTLFromConfigProfile is updateDurationFromConfigProfile for
+// session_ttl, which can also be "saml" to use the SessionDuration attribute
+// set by the Okta admin
+func updateSessionTTLFromConfigProfile(profiles lib.Profiles, profile string) error {
+	if fromProfile, _, err := profiles.GetValue(profile, "session_ttl"); err == nil && fromProfile == "saml" {
+		sessionTTLFromSAML = true
+		return nil
+	}
+	return updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL)
+}
+
+// sessionTTLDefaulted returns whether sessionTTL is the flag's default, as
+// neither the flag, AWS_SESSION_TTL nor the profile's session_ttl set it
+func sessionTTLDefaulted(cmd *cobra.Command, profiles lib.Profiles, profile string) bool {
+	if cmd.Flags().Lookup("session-ttl").Changed {
+		return false
+	}
+	_, _, err := profiles.GetValue(profile, "session_ttl")
+	return err != nil
+}
+
+// sessionAttributeVars returns the AWS_OKTA_* variables for the attributes
+// of the SAML assertion the session was created with, skipping unset ones
+func sessionAttributeVars(attrs saml.SessionAttributes) [][2]string {
+	var vars [][2]string
+	if attrs.RoleSessionName != "" {
+		vars = append(vars, [2]string{"AWS_OKTA_ROLE_SESSION_NAME", attrs.RoleSessionName})
+	}
+	if attrs.SourceIdentity != "" {
+		vars = append(vars, [2]string{"AWS_OKTA_SOURCE_IDENTITY", attrs.SourceIdentity})
+	}
+	if len(attrs.PrincipalTags) > 0 {
+		var tags []string
+		for key, value := range attrs.PrincipalTags {
+			tags = append(tags, key+"="+value)
+		}
+		sort.Strings(tags)
+		vars = append(vars, [2]string{"AWS_OKTA_PRINCIPAL_TAGS", strings.Join(tags, ",")})
+	}
+	if len(attrs.TransitiveTagKeys) > 0 {
+		vars = append(vars, [2]string{"AWS_OKTA_TRANSITIVE_TAG_KEYS", strings.Join(attrs.TransitiveTagKeys, ",")})
+	}
+	return vars
+}
+
+// readSAMLFile reads the SAML response given with --saml-file; see
+// lib.ReadSAMLAssertion for the formats it can be in
+func readSAMLFile(path string) (*lib.SAMLAssertion, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading SAML response: %s", err)
+	}
+	return lib.ReadSAMLAssertion(data)
+}
+
+// sessionPolicyFromFlags returns the session policy given with --policy and
+// --policy-arn
+func sessionPolicyFromFlags() (lib.SessionPolicy, error) {
+	policy := lib.SessionPolicy{ARNs: policyARNs}
+	if sessionPolicy != "" {
+		var err error
+		if policy.Policy, err = lib.ReadSessionPolicy(sessionPolicy); err != nil {
+			return lib.SessionPolicy{}, err
+		}
+	}
+	return policy, nil
+}
+
 func execPre(cmd *cobra.Command, args []string) {
 	if err := loadDurationFlagFromEnv(cmd, "session-ttl", "AWS_SESSION_TTL", &sessionTTL); err != nil {
 		fmt.Fprintln(os.Stderr, "warning: failed to parse duration from AWS_SESSION_TTL")
@@ -152,23 +253,25 @@ func execRun(cmd *cobra.Command, args []string) error {
 
 	// check profile for both session durations if not explicitly set
 	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		if err := updateAssumeRoleTTLFromConfigProfile(profiles, profile); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse duration_seconds or assume_role_ttl from profile config")
 		}
 	}
 
 	if !cmd.Flags().Lookup("session-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+		if err := updateSessionTTLFromConfigProfile(profiles, profile); err != nil {
 			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
 		}
 	}
 
 	opts := lib.ProviderOptions{
-		MFAConfig:          mfaConfig,
-		Profiles:           profiles,
-		SessionDuration:    sessionTTL,
-		AssumeRoleDuration: assumeRoleTTL,
-		AssumeRoleArn:      assumeRoleARN,
+		MFAConfig:                mfaConfig,
+		Profiles:                 profiles,
+		SessionDuration:          sessionTTL,
+		SessionDurationFromSAML:  sessionTTLFromSAML,
+		SessionDurationDefaulted: sessionTTLDefaulted(cmd, profiles, profile),
+		AssumeRoleDuration:       assumeRoleTTL,
+		AssumeRoleArn:            assumeRoleARN,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -193,7 +296,18 @@ func execRun(cmd *cobra.Command, args []string) error {
 		})
 	}
 
+	if samlFile != "" {
+		if opts.SAMLAssertion, err = readSAMLFile(samlFile); err != nil {
+			return err
+		}
+	}
+
+	if opts.SessionPolicy, err = sessionPolicyFromFlags(); err != nil {
+		return err
+	}
+
 	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	opts.SessionCacheStore = flagSessionCache
 
 	p, err := lib.NewProvider(kr, profile, opts)
 	if err != nil {
@@ -237,6 +351,14 @@ func execRun(cmd *cobra.Command, args []string) error {
 
 	env.Set("AWS_OKTA_SESSION_EXPIRATION", fmt.Sprintf("%d", p.GetExpiration().Unix()))
 
+	env.Unset("AWS_OKTA_ROLE_SESSION_NAME")
+	env.Unset("AWS_OKTA_SOURCE_IDENTITY")
+	env.Unset("AWS_OKTA_PRINCIPAL_TAGS")
+	env.Unset("AWS_OKTA_TRANSITIVE_TAG_KEYS")
+	for _, v := range sessionAttributeVars(p.SessionAttributes()) {
+		env.Set(v[0], v[1])
+	}
+
 	ecmd := exec.Command(command, commandArgs...)
 	ecmd.Stdin = os.Stdin
 	ecmd.Stdout = os.Stdout
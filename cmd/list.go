@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"text/tabwriter"
 
 	analytics "github.com/segmentio/analytics-go"
@@ -49,6 +50,16 @@ func listProfileNames(profiles lib.Profiles) []string {
 	return profileNames
 }
 
+// sourceChain returns the profiles that the role of profile is assumed
+// through, e.g. "okta -> security", or what is wrong with them
+func sourceChain(profiles lib.Profiles, profile string) string {
+	chain, err := profiles.SourceChain(profile)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.Join(chain[:len(chain)-1], " -> ")
+}
+
 func listRun(cmd *cobra.Command, args []string) error {
 	profiles, err := listProfiles()
 	if err != nil {
@@ -57,13 +68,15 @@ func listRun(cmd *cobra.Command, args []string) error {
 
 	profileNames := listProfileNames(profiles)
 
+	aliases := lib.LoadAccountAliases(profiles)
+
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 2, '\t', 0)
-	fmt.Fprintln(w, "PROFILE\tARN\tSOURCE_ROLE\t")
+	fmt.Fprintln(w, "PROFILE\tARN\tSOURCE_CHAIN\tACCOUNT\t")
 	for _, profile := range profileNames {
 		v := profiles[profile]
 		if role, exist := v["role_arn"]; exist {
-			fmt.Fprintf(w, "%s\t%s\t%s\n", profile, role, v["source_profile"])
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", profile, role, sourceChain(profiles, profile), lib.AccountName(role, aliases))
 		}
 	}
 	w.Flush()
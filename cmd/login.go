@@ -33,6 +33,8 @@ func init() {
 	loginCmd.Flags().BoolVarP(&Stdout, "stdout", "s", false, "Print login URL to stdout instead of opening in default browser")
 	loginCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	loginCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	loginCmd.Flags().StringVar(&sessionPolicy, "policy", "", "Session policy (JSON or a file) to scope the credentials down with; overrides session_policy in profile")
+	loginCmd.Flags().StringSliceVar(&policyARNs, "policy-arn", nil, "Managed policy to scope the credentials down with, may be repeated; overrides policy_arns in profile")
 }
 
 func loginPre(cmd *cobra.Command, args []string) {
@@ -74,22 +76,24 @@ func loginRun(cmd *cobra.Command, args []string) error {
 
 	// check profile for both session durations if not explicitly set
 	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		if err := updateAssumeRoleTTLFromConfigProfile(profiles, profile); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse duration_seconds or assume_role_ttl from profile config")
 		}
 	}
 
 	if !cmd.Flags().Lookup("session-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+		if err := updateSessionTTLFromConfigProfile(profiles, profile); err != nil {
 			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
 		}
 	}
 
 	opts := lib.ProviderOptions{
-		MFAConfig:          mfaConfig,
-		Profiles:           profiles,
-		SessionDuration:    sessionTTL,
-		AssumeRoleDuration: assumeRoleTTL,
+		MFAConfig:                mfaConfig,
+		Profiles:                 profiles,
+		SessionDuration:          sessionTTL,
+		SessionDurationFromSAML:  sessionTTLFromSAML,
+		SessionDurationDefaulted: sessionTTLDefaulted(cmd, profiles, profile),
+		AssumeRoleDuration:       assumeRoleTTL,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -113,7 +117,12 @@ func loginRun(cmd *cobra.Command, args []string) error {
 		})
 	}
 
+	if opts.SessionPolicy, err = sessionPolicyFromFlags(); err != nil {
+		return err
+	}
+
 	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	opts.SessionCacheStore = flagSessionCache
 
 	p, err := lib.NewProvider(kr, profile, opts)
 	if err != nil {
@@ -142,6 +151,12 @@ func oktaLogin(p *lib.Provider) error {
 }
 
 func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles) error {
+	prof := profiles[profile]
+	partition, err := lib.PartitionForARN(prof["role_arn"], prof["region"])
+	if err != nil {
+		return err
+	}
+
 	creds, err := p.Retrieve()
 	if err != nil {
 		return err
@@ -156,7 +171,7 @@ func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles) erro
 		return err
 	}
 
-	req, err := http.NewRequest("GET", "https://signin.aws.amazon.com/federation", nil)
+	req, err := http.NewRequest("GET", partition.FederationURL(), nil)
 	if err != nil {
 		return err
 	}
@@ -191,17 +206,11 @@ func federatedLogin(p *lib.Provider, profile string, profiles lib.Profiles) erro
 		return err
 	}
 
-	destination := "https://console.aws.amazon.com/"
-	prof := profiles[profile]
-	if region, ok := prof["region"]; ok {
-		destination = fmt.Sprintf(
-			"https://%s.console.aws.amazon.com/console/home?region=%s",
-			region, region,
-		)
-	}
+	destination := partition.ConsoleURL(prof["region"])
 
 	loginURL := fmt.Sprintf(
-		"https://signin.aws.amazon.com/federation?Action=login&Issuer=aws-okta&Destination=%s&SigninToken=%s",
+		"%s?Action=login&Issuer=aws-okta&Destination=%s&SigninToken=%s",
+		partition.FederationURL(),
 		url.QueryEscape(destination),
 		url.QueryEscape(signinToken),
 	)
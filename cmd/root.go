@@ -40,9 +40,11 @@ var (
 	analyticsClient            analytics.Client
 	username                   string
 	flagSessionCacheSingleItem bool
+	flagSessionCache           string
 )
 
 const envSessionCacheSingleItem = "AWS_OKTA_SESSION_CACHE_SINGLE_ITEM"
+const envSessionCache = "AWS_OKTA_SESSION_CACHE"
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -94,6 +96,18 @@ func prerunE(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if !cmd.Flags().Lookup("session-cache").Changed {
+		if val, ok := os.LookupEnv(envSessionCache); ok {
+			flagSessionCache = val
+		}
+	}
+	switch flagSessionCache {
+	case "", lib.SessionCacheItemPerSession, lib.SessionCacheSingleItem, lib.SessionCacheFile:
+	default:
+		return fmt.Errorf("unknown session cache %q, expected %s, %s or %s", flagSessionCache,
+			lib.SessionCacheItemPerSession, lib.SessionCacheSingleItem, lib.SessionCacheFile)
+	}
+
 	if analyticsEnabled {
 		// set up analytics client
 		analyticsClient, _ = analytics.NewWithConfig(analyticsWriteKey, analytics.Config{
@@ -123,10 +137,14 @@ func init() {
 	}
 	RootCmd.PersistentFlags().StringVarP(&mfaConfig.Provider, "mfa-provider", "", "", "MFA Provider to use (eg DUO, OKTA, GOOGLE)")
 	RootCmd.PersistentFlags().StringVarP(&mfaConfig.FactorType, "mfa-factor-type", "", "", "MFA Factor Type to use (eg push, token:software:totp)")
-	RootCmd.PersistentFlags().StringVarP(&mfaConfig.DuoDevice, "mfa-duo-device", "", "phone1", "Device to use phone1, phone2, u2f or token")
+	RootCmd.PersistentFlags().StringVarP(&mfaConfig.DuoDevice, "mfa-duo-device", "", "phone1", "Device to use phone1, phone2, u2f, webauthn or token")
+	RootCmd.PersistentFlags().StringVarP(&mfaConfig.FidoDevice, "mfa-fido-device", "", "", "Security key to use: path:<hid path>, product:<name> or software (default: any plugged-in key)")
+	RootCmd.PersistentFlags().BoolVarP(&mfaConfig.AllSecurityKeys, "mfa-all-security-keys", "", false, "Challenge all enrolled FIDO security keys at once instead of selecting one")
 	RootCmd.PersistentFlags().StringVarP(&backend, "backend", "b", "", fmt.Sprintf("Secret backend to use %s", backendsAvailable))
 	RootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Enable debug logging")
 	RootCmd.PersistentFlags().BoolVarP(&flagSessionCacheSingleItem, "session-cache-single-item", "", false, fmt.Sprintf("(alpha) Enable single-item session cache; aka %s", envSessionCacheSingleItem))
+	RootCmd.PersistentFlags().StringVarP(&flagSessionCache, "session-cache", "", "", fmt.Sprintf("Where to cache sessions: %s (default), %s, or %s for an encrypted file; aka %s",
+		lib.SessionCacheItemPerSession, lib.SessionCacheSingleItem, lib.SessionCacheFile, envSessionCache))
 }
 
 func updateMfaConfig(cmd *cobra.Command, profiles lib.Profiles, profile string, config *lib.MFAConfig) {
@@ -151,6 +169,32 @@ func updateMfaConfig(cmd *cobra.Command, profiles lib.Profiles, profile string,
 		}
 	}
 
+	if !cmd.Flags().Lookup("mfa-fido-device").Changed {
+		mfaFidoDevice, ok := os.LookupEnv("AWS_OKTA_MFA_FIDO_DEVICE")
+		if ok {
+			config.FidoDevice = mfaFidoDevice
+		} else {
+			mfaFidoDevice, _, err := profiles.GetValue(profile, "mfa_fido_device")
+			if err == nil {
+				config.FidoDevice = mfaFidoDevice
+			}
+		}
+	}
+
+	if !cmd.Flags().Lookup("mfa-all-security-keys").Changed {
+		allSecurityKeys, ok := os.LookupEnv("AWS_OKTA_MFA_ALL_SECURITY_KEYS")
+		if !ok {
+			allSecurityKeys, _, _ = profiles.GetValue(profile, "mfa_all_security_keys")
+		}
+		if allSecurityKeys != "" {
+			if val, err := strconv.ParseBool(allSecurityKeys); err == nil {
+				config.AllSecurityKeys = val
+			} else {
+				fmt.Fprintln(os.Stderr, "warning: could not parse mfa_all_security_keys")
+			}
+		}
+	}
+
 	if !cmd.Flags().Lookup("mfa-factor-type").Changed {
 		mfaFactorType, ok := os.LookupEnv("AWS_OKTA_MFA_FACTOR_TYPE")
 		if ok {
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/99designs/keyring"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/spf13/cobra"
+)
+
+// samlCmd represents the saml command
+var samlCmd = &cobra.Command{
+	Use:       "saml <profile>",
+	Short:     "saml authenticates through okta and shows the SAML assertion for the profile",
+	RunE:      samlRun,
+	ValidArgs: listProfileNames(mustListProfiles()),
+}
+
+var (
+	samlXML bool
+	samlRaw bool
+)
+
+func init() {
+	RootCmd.AddCommand(samlCmd)
+	samlCmd.Flags().BoolVar(&samlXML, "xml", false, "Print the SAML response as indented XML")
+	samlCmd.Flags().BoolVar(&samlRaw, "raw", false, "Print the base64 encoded SAML response, as sent to AWS")
+}
+
+func samlRun(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return ErrTooFewArguments
+	}
+	if len(args) > 1 {
+		return ErrTooManyArguments
+	}
+	if samlXML && samlRaw {
+		return errors.New("--xml and --raw can't be used together")
+	}
+
+	profile := args[0]
+
+	config, err := lib.NewConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	profiles, err := config.Parse()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := profiles[profile]; !ok {
+		return fmt.Errorf("Profile '%s' not found in your aws config. Use list command to see configured profiles.", profile)
+	}
+
+	updateMfaConfig(cmd, profiles, profile, &mfaConfig)
+
+	opts := lib.ProviderOptions{
+		MFAConfig: mfaConfig,
+		Profiles:  profiles,
+	}
+
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("profile", profile).
+				Set("command", "saml"),
+		})
+	}
+
+	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	opts.SessionCacheStore = flagSessionCache
+
+	p, err := lib.NewProvider(kr, profile, opts)
+	if err != nil {
+		return err
+	}
+
+	assertion, err := p.GetSAMLAssertion()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case samlRaw:
+		raw, err := assertion.Base64()
+		if err != nil {
+			return err
+		}
+		fmt.Println(raw)
+		return nil
+	case samlXML:
+		return lib.WriteSAMLXML(os.Stdout, assertion)
+	}
+
+	aliases := lib.LoadAccountAliases(profiles)
+	lib.AddSigninPageAliases(assertion, aliases)
+	return lib.WriteSAMLSummary(os.Stdout, assertion, aliases)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/99designs/keyring"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	analytics "github.com/segmentio/analytics-go"
+	"github.com/segmentio/aws-okta/lib"
+	"github.com/segmentio/aws-okta/lib/mfa"
+	"github.com/spf13/cobra"
+)
+
+// softwareKeyCmd represents the software-key command
+var softwareKeyCmd = &cobra.Command{
+	Use:   "software-key",
+	Short: "software-key shows and enrolls the software security key used with --mfa-fido-device software",
+}
+
+var softwareKeyShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "print the credential ID and public key of the software security key, creating it if needed",
+	RunE:  softwareKeyShowRun,
+}
+
+var softwareKeyRegisterCmd = &cobra.Command{
+	Use:   "register <challenge> <app id>",
+	Short: "print the U2F registration of the software security key for an enrollment challenge, as JSON",
+	RunE:  softwareKeyRegisterRun,
+}
+
+func init() {
+	RootCmd.AddCommand(softwareKeyCmd)
+	softwareKeyCmd.AddCommand(softwareKeyShowCmd)
+	softwareKeyCmd.AddCommand(softwareKeyRegisterCmd)
+}
+
+// softwareAuthenticator opens the keyring and returns the software
+// authenticator whose key is stored in it
+func softwareAuthenticator(command string) (*mfa.SoftwareAuthenticator, error) {
+	var allowedBackends []keyring.BackendType
+	if backend != "" {
+		allowedBackends = append(allowedBackends, keyring.BackendType(backend))
+	}
+	kr, err := lib.OpenKeyring(allowedBackends)
+	if err != nil {
+		return nil, err
+	}
+
+	if analyticsEnabled && analyticsClient != nil {
+		analyticsClient.Enqueue(analytics.Track{
+			UserId: username,
+			Event:  "Ran Command",
+			Properties: analytics.NewProperties().
+				Set("backend", backend).
+				Set("aws-okta-version", version).
+				Set("command", command),
+		})
+	}
+
+	return &mfa.SoftwareAuthenticator{Keyring: kr}, nil
+}
+
+func softwareKeyShowRun(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+
+	authenticator, err := softwareAuthenticator("software-key show")
+	if err != nil {
+		return err
+	}
+	keyHandle, publicKey, err := authenticator.Credential()
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Credential ID: %s\n", keyHandle)
+	return pem.Encode(os.Stdout, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func softwareKeyRegisterRun(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return ErrTooFewArguments
+	}
+	if len(args) > 2 {
+		return ErrTooManyArguments
+	}
+
+	authenticator, err := softwareAuthenticator("software-key register")
+	if err != nil {
+		return err
+	}
+	response, err := authenticator.Register(&u2fhost.RegisterRequest{
+		Challenge: args[0],
+		AppId:     args[1],
+		Facet:     args[1],
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(response)
+}
@@ -32,6 +32,8 @@ func init() {
 	RootCmd.AddCommand(writeToCredentialsCmd)
 	writeToCredentialsCmd.Flags().DurationVarP(&sessionTTL, "session-ttl", "t", time.Hour, "Expiration time for okta role session")
 	writeToCredentialsCmd.Flags().DurationVarP(&assumeRoleTTL, "assume-role-ttl", "a", time.Hour, "Expiration time for assumed role")
+	writeToCredentialsCmd.Flags().StringVar(&sessionPolicy, "policy", "", "Session policy (JSON or a file) to scope the credentials down with; overrides session_policy in profile")
+	writeToCredentialsCmd.Flags().StringSliceVar(&policyARNs, "policy-arn", nil, "Managed policy to scope the credentials down with, may be repeated; overrides policy_arns in profile")
 }
 
 func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
@@ -58,13 +60,13 @@ func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
 
 	// check profile for both session durations if not explicitly set
 	if !cmd.Flags().Lookup("assume-role-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "assume_role_ttl", &assumeRoleTTL); err != nil {
-			fmt.Fprintln(os.Stderr, "warning: could not parse assume_role_ttl from profile config")
+		if err := updateAssumeRoleTTLFromConfigProfile(profiles, profile); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: could not parse duration_seconds or assume_role_ttl from profile config")
 		}
 	}
 
 	if !cmd.Flags().Lookup("session-ttl").Changed {
-		if err := updateDurationFromConfigProfile(profiles, profile, "session_ttl", &sessionTTL); err != nil {
+		if err := updateSessionTTLFromConfigProfile(profiles, profile); err != nil {
 			fmt.Fprintln(os.Stderr, "warning: could not parse session_ttl from profile config")
 		}
 	}
@@ -76,10 +78,12 @@ func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
 	}
 
 	opts := lib.ProviderOptions{
-		MFAConfig:          mfaConfig,
-		Profiles:           profiles,
-		SessionDuration:    sessionTTL,
-		AssumeRoleDuration: assumeRoleTTL,
+		MFAConfig:                mfaConfig,
+		Profiles:                 profiles,
+		SessionDuration:          sessionTTL,
+		SessionDurationFromSAML:  sessionTTLFromSAML,
+		SessionDurationDefaulted: sessionTTLDefaulted(cmd, profiles, profile),
+		AssumeRoleDuration:       assumeRoleTTL,
 	}
 
 	var allowedBackends []keyring.BackendType
@@ -104,7 +108,12 @@ func writeToCredentialsRun(cmd *cobra.Command, args []string) error {
 		})
 	}
 
+	if opts.SessionPolicy, err = sessionPolicyFromFlags(); err != nil {
+		return err
+	}
+
 	opts.SessionCacheSingleItem = flagSessionCacheSingleItem
+	opts.SessionCacheStore = flagSessionCache
 
 	p, err := lib.NewProvider(kr, profile, opts)
 	if err != nil {
@@ -0,0 +1,227 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// AccountAliasesSection is the aws config section that names accounts, as
+// `<account-id> = <name>`. These names take precedence over learnt aliases.
+const AccountAliasesSection = "account-aliases"
+
+// AccountAliasesFile caches the account aliases learnt from the AWS sign-in
+// page and IAM. Aliases aren't secret, so unlike sessions they are kept out
+// of the keyring, and listing profiles doesn't need to unlock it.
+const AccountAliasesFile = "~/.aws-okta/account-aliases.json"
+
+// AccountAliases maps account IDs to names. An empty name records that the
+// account has no alias, so that it isn't looked up again.
+type AccountAliases map[string]string
+
+// Name returns how to show accountID to the user: its alias followed by the
+// ID if it has one, or else the ID
+func (a AccountAliases) Name(accountID string) string {
+	if alias := a[accountID]; alias != "" {
+		return alias + " (" + accountID + ")"
+	}
+	return accountID
+}
+
+// AccountName returns the name of the account of roleARN, as Name does
+func AccountName(roleARN string, aliases AccountAliases) string {
+	accountID, _ := accountIDAndRoleFromRoleARN(roleARN)
+	return aliases.Name(accountID)
+}
+
+// LoadAccountAliases returns the cached account aliases, overridden by those
+// in the [account-aliases] section of profiles
+func LoadAccountAliases(profiles Profiles) AccountAliases {
+	aliases, err := readAccountAliasesFile(AccountAliasesFile)
+	if err != nil {
+		log.Debugf("Couldn't read account aliases: %s", err)
+		aliases = AccountAliases{}
+	}
+	for accountID, alias := range profiles[AccountAliasesSection] {
+		aliases[accountID] = alias
+	}
+	return aliases
+}
+
+// SaveAccountAliases adds aliases to the cache
+func SaveAccountAliases(aliases AccountAliases) error {
+	return updateAccountAliasesFile(AccountAliasesFile, aliases)
+}
+
+func readAccountAliasesFile(path string) (AccountAliases, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return AccountAliases{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	aliases := AccountAliases{}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, xerrors.Errorf("parsing %s: %w", path, err)
+	}
+	return aliases, nil
+}
+
+func updateAccountAliasesFile(path string, aliases AccountAliases) error {
+	cached, err := readAccountAliasesFile(path)
+	if err != nil {
+		// start over rather than keep failing on a broken file
+		log.Debugf("Replacing account aliases: %s", err)
+		cached = AccountAliases{}
+	}
+	for accountID, alias := range aliases {
+		cached[accountID] = alias
+	}
+
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path, err = homedir.Expand(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// the sign-in page lists accounts as "Account: <alias> (<id>)", or
+// "Account: <id>" for accounts without an alias
+var signinPageAccountRegex = regexp.MustCompile(
+	`class="saml-account-name">\s*Account:\s*(?:([^<(]*?)\s*\()?(\d{12})\)?\s*<`)
+
+func parseSigninPageAliases(body []byte) AccountAliases {
+	aliases := AccountAliases{}
+	for _, m := range signinPageAccountRegex.FindAllSubmatch(body, -1) {
+		aliases[string(m[2])] = string(m[1])
+	}
+	return aliases
+}
+
+// AddSigninPageAliases looks up the accounts of the assertion's roles that
+// aren't in aliases on the AWS sign-in page, which lists the roles of an
+// assertion by account alias. The aliases found are added to aliases and
+// cached. Failures are only logged, as aliases are a nicety.
+func AddSigninPageAliases(assertion *SAMLAssertion, aliases AccountAliases) {
+	roles, err := GetAssumableRolesFromSAML(assertion.Resp)
+	if err != nil || len(roles) == 0 {
+		return
+	}
+	missing := false
+	for _, role := range roles {
+		accountID, _ := accountIDAndRoleFromRoleARN(role.Role)
+		if _, ok := aliases[accountID]; !ok {
+			missing = true
+		}
+	}
+	if !missing {
+		return
+	}
+
+	partition, err := PartitionForARN(roles[0].Role, "")
+	if err != nil {
+		log.Debugf("Not looking up account aliases: %s", err)
+		return
+	}
+	samlResponse, err := assertion.Base64()
+	if err != nil {
+		return
+	}
+	log.Debug("Looking up account aliases on the AWS sign-in page")
+	resp, err := http.PostForm("https://"+partition.SigninHost+"/saml", url.Values{"SAMLResponse": {samlResponse}})
+	if err != nil {
+		log.Debugf("Couldn't get the AWS sign-in page: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		log.Debugf("Couldn't get the AWS sign-in page: %s %v", resp.Status, err)
+		return
+	}
+
+	found := parseSigninPageAliases(body)
+	for accountID, alias := range found {
+		// don't override names from the config
+		if _, ok := aliases[accountID]; !ok {
+			aliases[accountID] = alias
+		}
+	}
+	if err := SaveAccountAliases(found); err != nil {
+		log.Debugf("Couldn't cache account aliases: %s", err)
+	}
+}
+
+// learnAccountAlias caches the IAM alias of the account of creds, which are
+// for roleARN in region, if it isn't known yet. roleARN may be empty if it
+// isn't known, in which case the account is looked up with STS at
+// stsEndpoint.
+func learnAccountAlias(creds sts.Credentials, roleARN, region string, stsEndpoint endpoints.STSRegionalEndpoint, aliases AccountAliases) {
+	accountID, _ := accountIDAndRoleFromRoleARN(roleARN)
+	if _, ok := aliases[accountID]; ok {
+		return
+	}
+
+	conf, err := stsConfig(roleARN, region, stsEndpoint)
+	if err != nil {
+		return
+	}
+	conf.WithCredentials(credentials.NewStaticCredentials(
+		*creds.AccessKeyId,
+		*creds.SecretAccessKey,
+		*creds.SessionToken,
+	))
+	sess := aws_session.Must(aws_session.NewSession(conf))
+
+	if accountID == "" {
+		identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if err != nil {
+			log.Debugf("Couldn't get the account ID: %s", err)
+			return
+		}
+		accountID = aws.StringValue(identity.Account)
+		if _, ok := aliases[accountID]; ok {
+			return
+		}
+	}
+
+	log.Debugf("Looking up the IAM alias of account %s", accountID)
+	accountAliases, err := listAccountAliases(sess)
+	if err != nil {
+		log.Debugf("Couldn't list account aliases: %s", err)
+		return
+	}
+	alias := ""
+	if len(accountAliases) > 0 {
+		alias = accountAliases[0]
+	}
+	aliases[accountID] = alias
+	if err := SaveAccountAliases(AccountAliases{accountID: alias}); err != nil {
+		log.Debugf("Couldn't cache account aliases: %s", err)
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+const signinPage = `<fieldset>
+<div class="saml-account"><div onClick="expandCollapse(0);">
+<div class="saml-account-name">Account: prod (111111111111)</div>
+</div></div>
+<div class="saml-account"><div onClick="expandCollapse(1);">
+<div class="saml-account-name">Account: 222222222222</div>
+</div></div>
+</fieldset>`
+
+func TestParseSigninPageAliases(t *testing.T) {
+	assert.Equal(t, AccountAliases{
+		"111111111111": "prod",
+		"222222222222": "",
+	}, parseSigninPageAliases([]byte(signinPage)))
+}
+
+func TestAccountAliasesName(t *testing.T) {
+	aliases := AccountAliases{"111111111111": "prod", "222222222222": ""}
+	assert.Equal(t, "prod (111111111111)", aliases.Name("111111111111"))
+	assert.Equal(t, "222222222222", aliases.Name("222222222222"))
+	assert.Equal(t, "333333333333", aliases.Name("333333333333"))
+	assert.Equal(t, "prod (111111111111)", AccountName("arn:aws:iam::111111111111:role/admin", aliases))
+}
+
+func TestAccountAliasesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "aliases", "account-aliases.json")
+
+	aliases, err := readAccountAliasesFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, AccountAliases{}, aliases)
+
+	assert.NoError(t, updateAccountAliasesFile(path, AccountAliases{"111111111111": "prod"}))
+	assert.NoError(t, updateAccountAliasesFile(path, AccountAliases{"222222222222": ""}))
+
+	aliases, err = readAccountAliasesFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, AccountAliases{"111111111111": "prod", "222222222222": ""}, aliases)
+}
+
+func TestListAccountAliases(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %s", err)
+		}
+		assert.Equal(t, "ListAccountAliases", r.PostForm.Get("Action"))
+		assert.Equal(t, "2010-05-08", r.PostForm.Get("Version"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/iam/aws4_request")
+
+		fmt.Fprint(w, `<ListAccountAliasesResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+  <ListAccountAliasesResult>
+    <IsTruncated>false</IsTruncated>
+    <AccountAliases>
+      <member>prod</member>
+    </AccountAliases>
+  </ListAccountAliasesResult>
+  <ResponseMetadata><RequestId>request-1</RequestId></ResponseMetadata>
+</ListAccountAliasesResponse>`)
+	}))
+	defer server.Close()
+
+	sess := aws_session.Must(aws_session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", "token"),
+	}))
+	aliases, err := listAccountAliases(sess)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"prod"}, aliases)
+	}
+}
@@ -56,38 +56,71 @@ func (c *fileConfig) Parse() (Profiles, error) {
 	return profiles, nil
 }
 
-// sourceProfile returns either the defined source_profile or p if none exists
+// sourceProfile returns the first profile of p's source chain: the one
+// whose role is assumed with SAML, or p if it has no source_profile
 func sourceProfile(p string, from Profiles) string {
-	if conf, ok := from[p]; ok {
-		if source := conf["source_profile"]; source != "" {
-			return source
+	chain, err := from.SourceChain(p)
+	if err != nil {
+		// Provider.Retrieve reports the loop
+		return p
+	}
+	return chain[0]
+}
+
+// sources returns profile followed by its source_profile, that profile's
+// source_profile and so on, stopping before a profile seen already. It also
+// returns whether it stopped because of such a loop.
+func (p Profiles) sources(profile string) ([]string, bool) {
+	profiles := []string{profile}
+	seen := map[string]bool{profile: true}
+	for {
+		source := p[profile]["source_profile"]
+		if source == "" {
+			return profiles, false
 		}
+		if seen[source] {
+			return append(profiles, source), true
+		}
+		seen[source] = true
+		profiles = append(profiles, source)
+		profile = source
 	}
-	return p
 }
 
-func (p Profiles) GetValue(profile string, config_key string) (string, string, error) {
-	config_value, ok := p[profile][config_key]
-	if ok {
-		return config_value, profile, nil
+// SourceChain returns the profiles to go through to get credentials for
+// profile, following source_profile: it starts with the profile whose role is
+// assumed with SAML, then has the profiles whose roles are assumed in turn,
+// ending with profile. It returns an error if source_profile loops.
+func (p Profiles) SourceChain(profile string) ([]string, error) {
+	profiles, loop := p.sources(profile)
+	if loop {
+		return nil, fmt.Errorf("source_profile loops: %s", strings.Join(profiles, " -> "))
 	}
+	chain := make([]string, len(profiles))
+	for i, source := range profiles {
+		chain[len(profiles)-1-i] = source
+	}
+	return chain, nil
+}
 
-	// Lookup from the `source_profile`, if it exists
-	profile, ok = p[profile]["source_profile"]
-	if ok {
-		config_value, ok := p[profile][config_key]
+func (p Profiles) GetValue(profile string, config_key string) (string, string, error) {
+	// Lookup from the profile, then up its `source_profile` chain
+	sources, loop := p.sources(profile)
+	if loop {
+		sources = sources[:len(sources)-1]
+	}
+	for _, source := range sources {
+		config_value, ok := p[source][config_key]
 		if ok {
-			return config_value, profile, nil
+			return config_value, source, nil
 		}
-
 	}
 
 	// Fallback to `okta` if no profile supplies the value
-	profile = "okta"
-	config_value, ok = p[profile][config_key]
+	config_value, ok := p["okta"][config_key]
 	if ok {
-		return config_value, profile, nil
+		return config_value, "okta", nil
 	}
 
-	return "", "", fmt.Errorf("Could not find %s in %s, source profile, or okta", config_key, profile)
+	return "", "", fmt.Errorf("Could not find %s in %s, its source profiles, or okta", config_key, profile)
 }
@@ -1,6 +1,9 @@
 package lib
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestGetConfigValue(t *testing.T) {
 	config_profiles := make(Profiles)
@@ -87,9 +90,76 @@ func TestGetConfigValue(t *testing.T) {
 	})
 
 	t.Run("recursive traversing from child profile", func(t *testing.T) {
-		_, _, found_error := config_profiles.GetValue("profile_c", "key_c")
+		found_value, found_profile, found_error := config_profiles.GetValue("profile_c", "key_c")
+		if found_error != nil {
+			t.Error("Error when searching for key_c")
+		}
+
+		if found_profile != "profile_a" {
+			t.Error("key_c should have come from `profile_a`")
+		}
+
+		if found_value != "c-a" {
+			t.Error("The proper value for `key_c` should be `c-a`")
+		}
+	})
+
+	config_profiles["profile_loop_a"] = map[string]string{
+		"source_profile": "profile_loop_b",
+	}
+
+	config_profiles["profile_loop_b"] = map[string]string{
+		"source_profile": "profile_loop_a",
+		"key_g":          "g-loop-b",
+	}
+
+	t.Run("traversing a source_profile loop", func(t *testing.T) {
+		found_value, _, found_error := config_profiles.GetValue("profile_loop_a", "key_g")
+		if found_error != nil {
+			t.Error("Error when searching for key_g")
+		}
+
+		if found_value != "g-loop-b" {
+			t.Error("The proper value for `key_g` should be `g-loop-b`")
+		}
+
+		_, _, found_error = config_profiles.GetValue("profile_loop_a", "config_key")
 		if found_error == nil {
-			t.Error("Recursive searching should not work")
+			t.Error("Searching for a missing key should return an error")
 		}
 	})
 }
+
+func TestSourceChain(t *testing.T) {
+	profiles := Profiles{
+		"okta":        map[string]string{},
+		"landing":     map[string]string{"role_arn": "arn:aws:iam::111111111111:role/okta"},
+		"security":    map[string]string{"role_arn": "arn:aws:iam::222222222222:role/security", "source_profile": "landing"},
+		"workload":    map[string]string{"role_arn": "arn:aws:iam::333333333333:role/workload", "source_profile": "security"},
+		"break-glass": map[string]string{"role_arn": "arn:aws:iam::333333333333:role/break-glass", "source_profile": "workload"},
+		"loop-a":      map[string]string{"source_profile": "loop-b"},
+		"loop-b":      map[string]string{"source_profile": "loop-c"},
+		"loop-c":      map[string]string{"source_profile": "loop-a"},
+	}
+
+	chain, err := profiles.SourceChain("break-glass")
+	if err != nil {
+		t.Fatalf("getting chain: %s", err)
+	}
+	if strings.Join(chain, ",") != "landing,security,workload,break-glass" {
+		t.Errorf("unexpected chain %v", chain)
+	}
+	if source := sourceProfile("break-glass", profiles); source != "landing" {
+		t.Errorf("source of break-glass should be landing, not %s", source)
+	}
+
+	chain, err = profiles.SourceChain("landing")
+	if err != nil || strings.Join(chain, ",") != "landing" {
+		t.Errorf("unexpected chain %v (%v)", chain, err)
+	}
+
+	_, err = profiles.SourceChain("loop-a")
+	if err == nil || err.Error() != "source_profile loops: loop-a -> loop-b -> loop-c -> loop-a" {
+		t.Errorf("unexpected error for a loop: %v", err)
+	}
+}
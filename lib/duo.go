@@ -9,13 +9,13 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"net/url"
 
 	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	"github.com/segmentio/aws-okta/lib/mfa"
 
 	uniformResourceLocator "net/url"
 
@@ -29,6 +29,12 @@ type DuoClient struct {
 	Device     string
 	StateToken string
 	FactorID   string
+	// Authenticator signs U2F and WebAuthn challenges; if nil, plugged-in
+	// HID security keys are used
+	Authenticator mfa.Authenticator
+	// Transport makes the requests to Duo and Okta; if nil,
+	// http.DefaultTransport is used
+	Transport http.RoundTripper
 }
 
 type StatusResp struct {
@@ -48,10 +54,30 @@ type StatusResp struct {
 		Cookie     string `json:"cookie"`
 		Result     string `json:"result"`
 		ResultURL  string `json:"result_url"`
+
+		WebAuthnCredentialRequestOptions WebAuthnCredentialRequestOptions `json:"webauthn_credential_request_options"`
 	} `json:"response"`
 	Stat string `json:"stat"`
 }
 
+// WebAuthnCredentialRequestOptions is Duo's serialization of the
+// PublicKeyCredentialRequestOptions a browser would pass to
+// navigator.credentials.get
+type WebAuthnCredentialRequestOptions struct {
+	Challenge        string `json:"challenge"`
+	RpID             string `json:"rpId"`
+	SessionID        string `json:"sessionId"`
+	Timeout          int    `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+	AllowCredentials []struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"allowCredentials"`
+	Extensions struct {
+		AppID string `json:"appid"`
+	} `json:"extensions"`
+}
+
 type PromptResp struct {
 	Response struct {
 		Txid string `json:"txid"`
@@ -82,7 +108,7 @@ type FacetResponse struct {
 // U2F Signing Request returns some trusted urls that we need to lookup
 func (d *DuoClient) getTrustedFacet(appId string) (facetResponse *FacetResponse, err error) {
 
-	client := &http.Client{}
+	client := &http.Client{Transport: d.Transport}
 
 	req, err := http.NewRequest("GET", appId, nil)
 	if err != nil {
@@ -110,7 +136,8 @@ func (d *DuoClient) getTrustedFacet(appId string) (facetResponse *FacetResponse,
 // to fake Duo is order to use the CLI without any browser.
 //
 // The function perform three successive calls to retry the challenge data.
-// Wait for the user to perform the verification (Duo Push or Yubikey). And then
+// Wait for the user to perform the verification (Duo Push, or a U2F or WebAuthn
+// security key). And then
 // call the callback url.
 //
 // TODO: Use a Context to gracefully shutdown the thing and have a nice timeout
@@ -135,72 +162,14 @@ func (d *DuoClient) ChallengeU2f(verificationHost string) (err error) {
 		return
 	}
 
-	if status.Response.StatusCode == "u2f_sent" {
-		var response *u2fhost.AuthenticateResponse
-		allDevices := u2fhost.Devices()
-		// Filter only the devices that can be opened.
-		openDevices := []u2fhost.Device{}
-		for i, device := range allDevices {
-			err := device.Open()
-			if err == nil {
-				openDevices = append(openDevices, allDevices[i])
-				defer func(i int) {
-					allDevices[i].Close()
-				}(i)
-			}
-		}
-		if len(openDevices) == 0 {
-			return errors.New("no open u2f devices")
-		}
-
-		var (
-			err error
-		)
-		prompted := false
-		timeout := time.After(time.Second * 25)
-		interval := time.NewTicker(time.Millisecond * 250)
-		facet := "https://" + verificationHost
-		log.Debugf("Facet: %s", facet)
-		var req = &u2fhost.AuthenticateRequest{
-			Challenge: status.Response.U2FSignRequest[0].Challenge,
-			AppId:     status.Response.U2FSignRequest[0].AppID,
-			KeyHandle: status.Response.U2FSignRequest[0].KeyHandle,
-			Facet:     facet,
-		}
-		defer interval.Stop()
-		for {
-			if response != nil {
-				break
-			}
-			select {
-			case <-timeout:
-				fmt.Println("Failed to get registration response after 25 seconds")
-				break
-			case <-interval.C:
-				for _, device := range openDevices {
-					response, err = device.Authenticate(req)
-					if err == nil {
-						log.Printf("Authentication succeeded, continuing")
-					} else if _, ok := err.(*u2fhost.TestOfUserPresenceRequiredError); ok {
-						if !prompted {
-							fmt.Fprintln(os.Stderr, "Touch the flashing U2F device to authenticate...")
-							fmt.Fprintln(os.Stderr)
-						}
-						prompted = true
-					} else {
-						log.Printf("Got status response %#v\n", err)
-						break
-					}
-				}
-			}
-		}
-		//log.Debugf("response: %#v", response)
-		//if response != nil {
-		txid, err = d.DoU2FPromptFinish(sid, status.Response.U2FSignRequest[0].SessionID, response)
-		if err != nil {
-			return fmt.Errorf("Failed on U2F_final. Err: %s", err)
-		}
-		//}
+	switch status.Response.StatusCode {
+	case "u2f_sent":
+		txid, err = d.challengeU2FSignRequest(sid, status)
+	case "webauthn_sent":
+		txid, err = d.challengeWebAuthn(sid, status)
+	}
+	if err != nil {
+		return
 	}
 
 	log.Printf("Device: %s", d.Device)
@@ -225,6 +194,122 @@ func (d *DuoClient) ChallengeU2f(verificationHost string) (err error) {
 	return
 }
 
+func (d *DuoClient) authenticator() mfa.Authenticator {
+	if d.Authenticator != nil {
+		return d.Authenticator
+	}
+	return &mfa.HardwareAuthenticator{}
+}
+
+// challengeU2FSignRequest signs the legacy U2F request sent by Duo with
+// whichever security key the user touches, like a browser does, and sends the
+// response back to Duo
+func (d *DuoClient) challengeU2FSignRequest(sid string, status StatusResp) (txid string, err error) {
+	signRequests := status.Response.U2FSignRequest
+	if len(signRequests) == 0 {
+		return "", errors.New("Duo sent no U2F sign requests")
+	}
+
+	facet := "https://" + d.Host
+	log.Debugf("Facet: %s", facet)
+	reqs := make([]*u2fhost.AuthenticateRequest, len(signRequests))
+	for i, signRequest := range signRequests {
+		reqs[i] = &u2fhost.AuthenticateRequest{
+			Challenge: signRequest.Challenge,
+			AppId:     signRequest.AppID,
+			KeyHandle: signRequest.KeyHandle,
+			Facet:     facet,
+		}
+	}
+	i, response, err := mfa.AuthenticateAny(d.authenticator(), reqs)
+	if err != nil {
+		return "", fmt.Errorf("Failed U2F challenge. Err: %s", err)
+	}
+
+	txid, err = d.DoU2FPromptFinish(sid, signRequests[i].SessionID, response)
+	if err != nil {
+		return "", fmt.Errorf("Failed on U2F_final. Err: %s", err)
+	}
+	return txid, nil
+}
+
+// challengeWebAuthn signs the WebAuthn credential request sent by Duo and
+// sends the assertion back to Duo
+func (d *DuoClient) challengeWebAuthn(sid string, status StatusResp) (txid string, err error) {
+	responseData, err := d.SignWebAuthn(status.Response.WebAuthnCredentialRequestOptions)
+	if err != nil {
+		return "", fmt.Errorf("Failed WebAuthn challenge. Err: %s", err)
+	}
+
+	txid, err = d.DoWebAuthnPromptFinish(sid, responseData)
+	if err != nil {
+		return "", fmt.Errorf("Failed on webauthn_finish. Err: %s", err)
+	}
+	return txid, nil
+}
+
+// SignWebAuthn gets an assertion for one of the allowed credentials of a Duo
+// WebAuthn request.
+//
+// Credentials are tried against the relying party ID. Security keys that were
+// enrolled with Duo's legacy U2F prompt are only known under the U2F AppID, so
+// if Duo asks for the appid extension, they are tried against it as well. All
+// requests go to the authenticator at once, which signs the first one a key
+// is touched for.
+func (d *DuoClient) SignWebAuthn(opts WebAuthnCredentialRequestOptions) (*WebAuthnResponseData, error) {
+	if len(opts.AllowCredentials) == 0 {
+		return nil, errors.New("Duo sent no allowed WebAuthn credentials")
+	}
+
+	appIDs := []string{opts.RpID}
+	if opts.Extensions.AppID != "" {
+		appIDs = append(appIDs, opts.Extensions.AppID)
+	}
+
+	// every credential under every AppID is challenged at once, so that the
+	// user touches whichever key they have without waiting on the others
+	var reqs []*u2fhost.AuthenticateRequest
+	var reqAppIDs, reqCredentialIDs []string
+	for _, appID := range appIDs {
+		for _, credential := range opts.AllowCredentials {
+			reqs = append(reqs, &u2fhost.AuthenticateRequest{
+				Challenge: opts.Challenge,
+				AppId:     appID,
+				KeyHandle: strings.TrimRight(credential.ID, "="),
+				Facet:     "https://" + d.Host,
+				WebAuthn:  true,
+			})
+			reqAppIDs = append(reqAppIDs, appID)
+			reqCredentialIDs = append(reqCredentialIDs, credential.ID)
+		}
+	}
+	i, response, err := mfa.AuthenticateAny(d.authenticator(), reqs)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("WebAuthn credential %s was used for %s", reqCredentialIDs[i], reqAppIDs[i])
+
+	data := &WebAuthnResponseData{
+		SessionID:         opts.SessionID,
+		ID:                reqCredentialIDs[i],
+		RawID:             reqCredentialIDs[i],
+		Type:              "public-key",
+		AuthenticatorData: toBase64URL(response.AuthenticatorData),
+		ClientDataJSON:    toBase64URL(response.ClientData),
+		Signature:         toBase64URL(response.SignatureData),
+	}
+	data.ExtensionResults.AppID = reqAppIDs[i] != opts.RpID
+	return data, nil
+}
+
+// toBase64URL re-encodes standard or URL-safe base64 as unpadded URL-safe
+// base64, which is what Duo expects for every binary WebAuthn field
+func toBase64URL(s string) string {
+	s = strings.TrimRight(s, "=")
+	s = strings.Replace(s, "+", "-", -1)
+	return strings.Replace(s, "/", "_", -1)
+}
+
 // It's same as u2fhost.AuthenticateResponse but needs SessionID for Duo/Okta
 type ResponseData struct {
 	ClientData    string `json:"clientData"`
@@ -252,6 +337,7 @@ func (d *DuoClient) DoAuth(tx string, inputSid string, inputCertsURL string) (si
 	)
 
 	client := &http.Client{
+		Transport: d.Transport,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
@@ -311,7 +397,7 @@ func (d *DuoClient) DoU2FPromptFinish(sid string, sessionID string, resp *u2fhos
 
 	promptUrl := "https://" + d.Host + "/frame/prompt"
 
-	client := &http.Client{}
+	client := &http.Client{Transport: d.Transport}
 
 	var respData = ResponseData{
 		SessionID:     sessionID,
@@ -329,7 +415,7 @@ func (d *DuoClient) DoU2FPromptFinish(sid string, sessionID string, resp *u2fhos
 	// whether you want to use a token or a phone of some sort
 	// it may make sense to make a selector in CLI similar to the Okta UI but
 	// I'm not certain that belongs here
-	if d.Device == "u2f" {
+	if d.Device == "u2f" || d.Device == "webauthn" {
 		promptData = "sid=" + sid + "&device=u2f_token&factor=u2f_finish&out_of_date=False&days_out_of_date=0&response_data=" + url.QueryEscape(string(respJSON))
 	} else {
 		err = fmt.Errorf("U2F Prompt final only applies to u2f devices, not %s", d.Device)
@@ -364,6 +450,68 @@ func (d *DuoClient) DoU2FPromptFinish(sid string, sessionID string, resp *u2fhos
 	return
 }
 
+// WebAuthnResponseData is the PublicKeyCredential sent back to Duo to
+// finish a WebAuthn prompt
+type WebAuthnResponseData struct {
+	SessionID         string `json:"sessionId"`
+	ID                string `json:"id"`
+	RawID             string `json:"rawId"`
+	Type              string `json:"type"`
+	AuthenticatorData string `json:"authenticatorData"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	Signature         string `json:"signature"`
+	ExtensionResults  struct {
+		AppID bool `json:"appid"`
+	} `json:"extensionResults"`
+}
+
+// DoWebAuthnPromptFinish sends the signed WebAuthn assertion to the Duo
+// /frame/prompt endpoint
+//
+// The functions returns the Duo transaction ID which is different from
+// the Okta transaction ID
+func (d *DuoClient) DoWebAuthnPromptFinish(sid string, data *WebAuthnResponseData) (txid string, err error) {
+	var req *http.Request
+
+	promptUrl := "https://" + d.Host + "/frame/prompt"
+
+	client := &http.Client{Transport: d.Transport}
+
+	respJSON, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	promptData := "sid=" + sid + "&device=webauthn_credential&factor=webauthn_finish&out_of_date=False&days_out_of_date=0&response_data=" + url.QueryEscape(string(respJSON))
+
+	req, err = http.NewRequest("POST", promptUrl, bytes.NewReader([]byte(promptData)))
+	if err != nil {
+		return
+	}
+
+	req.Header.Add("Origin", "https://"+d.Host)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("X-Requested-With", "XMLHttpRequest")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("WebAuthn Prompt request failed: %d", res.StatusCode)
+		return
+	}
+
+	var status PromptResp
+	err = json.NewDecoder(res.Body).Decode(&status)
+
+	txid = status.Response.Txid
+
+	return
+}
+
 // DoPrompt sends a POST request to the Duo /frame/promt endpoint
 //
 // The functions returns the Duo transaction ID which is different from
@@ -376,7 +524,7 @@ func (d *DuoClient) DoPrompt(sid string) (txid string, err error) {
 
 	url := "https://" + d.Host + "/frame/prompt"
 
-	client := &http.Client{}
+	client := &http.Client{Transport: d.Transport}
 
 	// Pick between device you want to use -- the flow are bit different depending on
 	// whether you want to use a token or a phone of some sort
@@ -395,6 +543,8 @@ func (d *DuoClient) DoPrompt(sid string) (txid string, err error) {
 		promptData = "sid=" + sid + "&device=token&factor=Passcode&passcode=" + text + "&out_of_date=False&days_out_of_date=0"
 	} else if d.Device == "u2f" {
 		promptData = "sid=" + sid + "&device=u2f_token&factor=U2F+Token&out_of_date=False&days_out_of_date=0"
+	} else if d.Device == "webauthn" {
+		promptData = "sid=" + sid + "&device=webauthn_credential&factor=WebAuthn+Credential&out_of_date=False&days_out_of_date=0"
 	} else {
 		promptData = "sid=" + sid + "&device=" + d.Device + "&factor=Duo+Push&out_of_date=False"
 	}
@@ -436,7 +586,7 @@ func (d *DuoClient) DoStatus(txid, sid string) (auth string, status StatusResp,
 
 	url := "https://" + d.Host + "/frame/status"
 
-	client := &http.Client{}
+	client := &http.Client{Transport: d.Transport}
 
 	statusData := "sid=" + sid + "&txid=" + txid
 	req, err = http.NewRequest("POST", url, bytes.NewReader([]byte(statusData)))
@@ -479,7 +629,7 @@ func (d *DuoClient) DoStatus(txid, sid string) (auth string, status StatusResp,
 }
 
 func (d *DuoClient) DoRedirect(url string, sid string) (string, error) {
-	client := http.Client{}
+	client := http.Client{Transport: d.Transport}
 	statusData := "sid=" + sid
 	url = "https://" + d.Host + url
 	req, err := http.NewRequest("POST", url, bytes.NewReader([]byte(statusData)))
@@ -522,7 +672,7 @@ func (d *DuoClient) DoCallback(auth string) (err error) {
 
 	sigResp := auth + ":" + app
 
-	client := &http.Client{}
+	client := &http.Client{Transport: d.Transport}
 
 	callbackData := "id=" + d.FactorID + "&stateToken=" + d.StateToken + "&sig_response=" + sigResp
 	req, err = http.NewRequest("POST", d.Callback, bytes.NewReader([]byte(callbackData)))
@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeU2FAuthenticator only knows the key handles in keys, per AppID
+type fakeU2FAuthenticator struct {
+	keys     map[string]string
+	requests []u2fhost.AuthenticateRequest
+}
+
+func (f *fakeU2FAuthenticator) Authenticate(req *u2fhost.AuthenticateRequest) (*u2fhost.AuthenticateResponse, error) {
+	f.requests = append(f.requests, *req)
+	if f.keys[req.AppId] != req.KeyHandle {
+		return nil, &u2fhost.BadKeyHandleError{}
+	}
+	return &u2fhost.AuthenticateResponse{
+		KeyHandle:         req.KeyHandle,
+		ClientData:        "Y2xpZW50-ZGF0YQ",
+		SignatureData:     "c2lnbm/+YXR1cmU=",
+		AuthenticatorData: "YXV0aGRh/+E=",
+	}, nil
+}
+
+// fakeMultiU2FAuthenticator is a fakeU2FAuthenticator that is given all
+// requests at once, like HardwareAuthenticator
+type fakeMultiU2FAuthenticator struct {
+	fakeU2FAuthenticator
+	batches [][]*u2fhost.AuthenticateRequest
+}
+
+func (f *fakeMultiU2FAuthenticator) AuthenticateAny(reqs []*u2fhost.AuthenticateRequest) (int, *u2fhost.AuthenticateResponse, error) {
+	f.batches = append(f.batches, reqs)
+	for i, req := range reqs {
+		if response, err := f.Authenticate(req); err == nil {
+			return i, response, nil
+		}
+	}
+	return 0, nil, &u2fhost.BadKeyHandleError{}
+}
+
+const duoWebAuthnStatus = `{
+	"stat": "OK",
+	"response": {
+		"status_code": "webauthn_sent",
+		"webauthn_credential_request_options": {
+			"challenge": "Y2hhbGxlbmdl",
+			"rpId": "duosecurity.com",
+			"sessionId": "session-1",
+			"timeout": 60000,
+			"userVerification": "discouraged",
+			"allowCredentials": [
+				{"type": "public-key", "id": "a2V5LW9uZQ"},
+				{"type": "public-key", "id": "a2V5LXR3bw=="}
+			],
+			"extensions": {"appid": "https://api-1234.duosecurity.com"}
+		}
+	}
+}`
+
+func TestDuoSignWebAuthn(t *testing.T) {
+	var status StatusResp
+	if err := json.Unmarshal([]byte(duoWebAuthnStatus), &status); err != nil {
+		t.Fatalf("unmarshalling status: %s", err)
+	}
+	opts := status.Response.WebAuthnCredentialRequestOptions
+
+	t.Run("credential for relying party", func(t *testing.T) {
+		authenticator := &fakeMultiU2FAuthenticator{
+			fakeU2FAuthenticator: fakeU2FAuthenticator{keys: map[string]string{"duosecurity.com": "a2V5LXR3bw"}},
+		}
+		d := &DuoClient{Host: "api-1234.duosecurity.com", Authenticator: authenticator}
+
+		data, err := d.SignWebAuthn(opts)
+		if err != nil {
+			t.Fatalf("signing: %s", err)
+		}
+
+		assert.Equal(t, "session-1", data.SessionID)
+		assert.Equal(t, "a2V5LXR3bw==", data.ID)
+		assert.Equal(t, "public-key", data.Type)
+		assert.Equal(t, "YXV0aGRh_-E", data.AuthenticatorData)
+		assert.Equal(t, "Y2xpZW50-ZGF0YQ", data.ClientDataJSON)
+		assert.Equal(t, "c2lnbm_-YXR1cmU", data.Signature)
+		assert.False(t, data.ExtensionResults.AppID)
+
+		// every credential under both AppIDs is challenged at once
+		if assert.Len(t, authenticator.batches, 1) && assert.Len(t, authenticator.batches[0], 4) {
+			for i, appID := range []string{"duosecurity.com", "duosecurity.com", "https://api-1234.duosecurity.com", "https://api-1234.duosecurity.com"} {
+				req := authenticator.batches[0][i]
+				assert.Equal(t, appID, req.AppId)
+				assert.True(t, req.WebAuthn)
+				assert.Equal(t, "Y2hhbGxlbmdl", req.Challenge)
+				assert.Equal(t, "https://api-1234.duosecurity.com", req.Facet)
+			}
+			assert.Equal(t, "a2V5LW9uZQ", authenticator.batches[0][0].KeyHandle)
+			assert.Equal(t, "a2V5LXR3bw", authenticator.batches[0][1].KeyHandle)
+		}
+	})
+
+	t.Run("legacy U2F credential uses appid extension", func(t *testing.T) {
+		authenticator := &fakeU2FAuthenticator{keys: map[string]string{"https://api-1234.duosecurity.com": "a2V5LW9uZQ"}}
+		d := &DuoClient{Host: "api-1234.duosecurity.com", Authenticator: authenticator}
+
+		data, err := d.SignWebAuthn(opts)
+		if err != nil {
+			t.Fatalf("signing: %s", err)
+		}
+
+		assert.Equal(t, "a2V5LW9uZQ", data.ID)
+		assert.True(t, data.ExtensionResults.AppID)
+		assert.Len(t, authenticator.requests, 3)
+	})
+
+	t.Run("unknown credentials", func(t *testing.T) {
+		d := &DuoClient{Host: "api-1234.duosecurity.com", Authenticator: &fakeU2FAuthenticator{}}
+
+		_, err := d.SignWebAuthn(opts)
+		if _, ok := err.(*u2fhost.BadKeyHandleError); !ok {
+			t.Fatalf("expected BadKeyHandleError; got %v", err)
+		}
+	})
+}
+
+func TestDuoChallengeWebAuthn(t *testing.T) {
+	var callbacks int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing request: %s", err)
+		}
+		switch r.URL.Path {
+		case "/frame/web/v1/auth":
+			assert.Equal(t, "TX|tx", r.URL.Query().Get("tx"))
+			w.Header().Set("Location", "/frame/prompt?sid=sid-1")
+			w.WriteHeader(http.StatusFound)
+		case "/frame/prompt":
+			assert.Equal(t, "sid-1", r.PostForm.Get("sid"))
+			assert.Equal(t, "webauthn_credential", r.PostForm.Get("device"))
+			if r.PostForm.Get("factor") != "webauthn_finish" {
+				assert.Equal(t, "WebAuthn Credential", r.PostForm.Get("factor"))
+				fmt.Fprint(w, `{"stat": "OK", "response": {"txid": "tx-1"}}`)
+				return
+			}
+			var data WebAuthnResponseData
+			if err := json.Unmarshal([]byte(r.PostForm.Get("response_data")), &data); err != nil {
+				t.Errorf("decoding response data: %s", err)
+			}
+			assert.Equal(t, "session-1", data.SessionID)
+			assert.Equal(t, "a2V5LXR3bw==", data.ID)
+			assert.Equal(t, "c2lnbm_-YXR1cmU", data.Signature)
+			fmt.Fprint(w, `{"stat": "OK", "response": {"txid": "tx-2"}}`)
+		case "/frame/status":
+			switch txid := r.PostForm.Get("txid"); txid {
+			case "tx-1":
+				fmt.Fprint(w, duoWebAuthnStatus)
+			case "tx-2":
+				fmt.Fprint(w, `{"stat": "OK", "response": {"status_code": "allow", "result": "SUCCESS", "cookie": "AUTH|cookie"}}`)
+			default:
+				t.Errorf("unexpected txid %q", txid)
+			}
+		case "/callback":
+			callbacks++
+			assert.Equal(t, "factor-1", r.PostForm.Get("id"))
+			assert.Equal(t, "state-token", r.PostForm.Get("stateToken"))
+			assert.Equal(t, "AUTH|cookie:APP|app", r.PostForm.Get("sig_response"))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	d := NewDuoClient(strings.TrimPrefix(server.URL, "https://"), "TX|tx:APP|app", server.URL+"/callback", "factor-1")
+	d.Device = "webauthn"
+	d.StateToken = "state-token"
+	d.Transport = server.Client().Transport
+	d.Authenticator = &fakeU2FAuthenticator{keys: map[string]string{"duosecurity.com": "a2V5LXR3bw"}}
+
+	if err := d.ChallengeU2f(""); err != nil {
+		t.Fatalf("challenging: %s", err)
+	}
+	assert.Equal(t, 1, callbacks)
+}
+
+func TestDuoDoWebAuthnPromptFinishError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	d := &DuoClient{Host: strings.TrimPrefix(server.URL, "https://"), Transport: server.Client().Transport}
+	_, err := d.DoWebAuthnPromptFinish("sid-1", &WebAuthnResponseData{})
+	assert.EqualError(t, err, "WebAuthn Prompt request failed: 400")
+}
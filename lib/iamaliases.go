@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/query"
+)
+
+// iamAPIVersion is the version of the IAM query API
+const iamAPIVersion = "2010-05-08"
+
+type listAccountAliasesInput struct {
+	_ struct{} `type:"structure"`
+}
+
+type listAccountAliasesOutput struct {
+	_ struct{} `type:"structure"`
+
+	AccountAliases []*string `type:"list" required:"true"`
+}
+
+// newIAMClient returns an IAM client for p, like iam.New would. ListAccountAliases
+// is the only IAM call aws-okta makes, so rather than vendor the whole IAM
+// client, it is built here the way the SDK builds its clients, on the query
+// protocol that STS uses too.
+func newIAMClient(p client.ConfigProvider, cfgs ...*aws.Config) *client.Client {
+	c := p.ClientConfig("iam", cfgs...)
+	svc := client.New(
+		*c.Config,
+		metadata.ClientInfo{
+			ServiceName:   "iam",
+			ServiceID:     "IAM",
+			SigningName:   c.SigningName,
+			SigningRegion: c.SigningRegion,
+			PartitionID:   c.PartitionID,
+			Endpoint:      c.Endpoint,
+			APIVersion:    iamAPIVersion,
+		},
+		c.Handlers,
+	)
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(query.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(query.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(query.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(query.UnmarshalErrorHandler)
+	return svc
+}
+
+// listAccountAliases returns the IAM aliases of the account of the
+// credentials p is configured with; an account has at most one
+func listAccountAliases(p client.ConfigProvider, cfgs ...*aws.Config) ([]string, error) {
+	op := &request.Operation{
+		Name:       "ListAccountAliases",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &listAccountAliasesOutput{}
+	req := newIAMClient(p, cfgs...).NewRequest(op, &listAccountAliasesInput{}, output)
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return aws.StringValueSlice(output.AccountAliases), nil
+}
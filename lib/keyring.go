@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/99designs/keyring"
@@ -10,16 +11,73 @@ func keyringPrompt(prompt string) (string, error) {
 	return PromptWithOutput(prompt, true, os.Stderr)
 }
 
+// promptingKeyring is a keyring that prompts for a passphrase to read its
+// items, which is the file backend
+type promptingKeyring struct {
+	keyring.Keyring
+}
+
+// KeyringPrompts returns whether kr, as opened by OpenKeyring, prompts for a
+// passphrase to read its items, as when there is no system keyring
+func KeyringPrompts(kr keyring.Keyring) bool {
+	_, ok := kr.(*promptingKeyring)
+	return ok
+}
+
+// openKeyringError is returned by OpenKeyring when no backend could be
+// opened. It is keyring.ErrNoAvailImpl, and wraps the error of the last
+// backend tried, which tells the user why, e.g. a locked keychain.
+type openKeyringError struct {
+	backend keyring.BackendType
+	err     error
+}
+
+func (e *openKeyringError) Error() string {
+	return fmt.Sprintf("%s; the %s backend failed: %s", keyring.ErrNoAvailImpl, e.backend, e.err)
+}
+
+func (e *openKeyringError) Is(target error) bool {
+	return target == keyring.ErrNoAvailImpl
+}
+
+func (e *openKeyringError) Unwrap() error {
+	return e.err
+}
+
+// OpenKeyring opens the first of allowedBackends that works, or of all the
+// available backends if it is nil
+//
+// If none does, it returns an error matching keyring.ErrNoAvailImpl, that
+// wraps the error of the last backend tried.
 func OpenKeyring(allowedBackends []keyring.BackendType) (kr keyring.Keyring, err error) {
-	kr, err = keyring.Open(keyring.Config{
-		AllowedBackends:          allowedBackends,
-		KeychainTrustApplication: true,
-		// this keychain name is for backwards compatibility
-		ServiceName:             "aws-okta-login",
-		LibSecretCollectionName: "awsvault",
-		FileDir:                 "~/.aws-okta/",
-		FilePasswordFunc:        keyringPrompt,
-	})
-
-	return
+	if allowedBackends == nil {
+		allowedBackends = keyring.AvailableBackends()
+	}
+	// the backends are tried in turn as keyring.Open does, to know which one
+	// is used
+	var lastErr *openKeyringError
+	for _, backend := range allowedBackends {
+		kr, err = keyring.Open(keyring.Config{
+			AllowedBackends:          []keyring.BackendType{backend},
+			KeychainTrustApplication: true,
+			// this keychain name is for backwards compatibility
+			ServiceName:             "aws-okta-login",
+			LibSecretCollectionName: "awsvault",
+			FileDir:                 "~/.aws-okta/",
+			FilePasswordFunc:        keyringPrompt,
+		})
+		if err != nil {
+			lastErr = &openKeyringError{backend: backend, err: err}
+			continue
+		}
+		if backend == keyring.FileBackend {
+			return &promptingKeyring{kr}, nil
+		}
+		return kr, nil
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, keyring.ErrNoAvailImpl
 }
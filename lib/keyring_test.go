@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/stretchr/testify/assert"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+func TestOpenKeyringError(t *testing.T) {
+	locked := errors.New("keychain is locked")
+	err := error(&openKeyringError{backend: keyring.KeychainBackend, err: locked})
+
+	assert.True(t, xerrors.Is(err, keyring.ErrNoAvailImpl))
+	assert.True(t, xerrors.Is(err, locked))
+	assert.EqualError(t, err, "Specified keyring backend not available; the keychain backend failed: keychain is locked")
+
+	// a backend that doesn't exist fails too
+	_, err = OpenKeyring([]keyring.BackendType{"nonexistent"})
+	assert.True(t, xerrors.Is(err, keyring.ErrNoAvailImpl), "got %v", err)
+	assert.Contains(t, err.Error(), "nonexistent")
+}
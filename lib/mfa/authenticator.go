@@ -0,0 +1,70 @@
+package mfa
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/99designs/keyring"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+)
+
+// Authenticator signs U2F and WebAuthn challenges with a security key
+//
+// Requests and responses use the go-u2fhost types, so that hardware keys can be
+// swapped for a software token in tests and CI. Authenticate returns
+// *u2fhost.BadKeyHandleError when the authenticator doesn't hold the requested
+// key handle.
+type Authenticator interface {
+	Authenticate(*u2fhost.AuthenticateRequest) (*u2fhost.AuthenticateResponse, error)
+}
+
+// MultiAuthenticator is implemented by authenticators that can wait on
+// several requests at once, the way a browser does
+type MultiAuthenticator interface {
+	AuthenticateAny([]*u2fhost.AuthenticateRequest) (int, *u2fhost.AuthenticateResponse, error)
+}
+
+// AuthenticateAny signs the first request that a key handle is found for, and
+// returns its index
+//
+// If a implements MultiAuthenticator, all requests are tried at once. Otherwise
+// they are tried in order, skipping those whose key handle is unknown.
+func AuthenticateAny(a Authenticator, reqs []*u2fhost.AuthenticateRequest) (int, *u2fhost.AuthenticateResponse, error) {
+	if multi, ok := a.(MultiAuthenticator); ok {
+		return multi.AuthenticateAny(reqs)
+	}
+
+	err := error(&u2fhost.BadKeyHandleError{})
+	for i, req := range reqs {
+		var response *u2fhost.AuthenticateResponse
+		response, err = a.Authenticate(req)
+		if _, ok := err.(*u2fhost.BadKeyHandleError); ok {
+			continue
+		}
+		return i, response, err
+	}
+	return 0, nil, err
+}
+
+// NewAuthenticator returns the Authenticator described by spec:
+//
+//	""                 any plugged-in security key
+//	"path:<path>"      the security key at this platform-specific HID path
+//	"product:<name>"   security keys whose product string contains name
+//	"software"         a software token whose key is stored in kr
+func NewAuthenticator(spec string, kr keyring.Keyring) (Authenticator, error) {
+	switch {
+	case spec == "":
+		return &HardwareAuthenticator{}, nil
+	case spec == "software":
+		if kr == nil {
+			return nil, fmt.Errorf("the software authenticator needs a keyring")
+		}
+		return &SoftwareAuthenticator{Keyring: kr}, nil
+	case strings.HasPrefix(spec, "path:"):
+		return &HardwareAuthenticator{Path: strings.TrimPrefix(spec, "path:")}, nil
+	case strings.HasPrefix(spec, "product:"):
+		return &HardwareAuthenticator{Product: strings.TrimPrefix(spec, "product:")}, nil
+	}
+	return nil, fmt.Errorf("invalid FIDO device %q; use software, path:<path> or product:<name>", spec)
+}
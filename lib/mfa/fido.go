@@ -3,26 +3,15 @@ package mfa
 import (
 	"errors"
 	"fmt"
-	"time"
-
-	log "github.com/sirupsen/logrus"
+	"os"
 
 	u2fhost "github.com/marshallbrekka/go-u2fhost"
 )
 
-const (
-	MaxOpenRetries = 10
-	RetryDelayMS   = 200 * time.Millisecond
-)
-
-var (
-	errNoDeviceFound = fmt.Errorf("no U2F devices found. device might not be plugged in")
-)
-
 type FidoClient struct {
 	ChallengeNonce string
 	AppId          string
-	Device         u2fhost.Device
+	Authenticator  Authenticator
 	KeyHandle      string
 	StateToken     string
 }
@@ -34,41 +23,28 @@ type SignedAssertion struct {
 	AuthenticatorData string `json:"authenticatorData"`
 }
 
+// NewFidoClient returns a FidoClient using any plugged-in security key
 func NewFidoClient(challengeNonce, appId, keyHandle, stateToken string) (FidoClient, error) {
-	var device u2fhost.Device
-	var err error
-
-	retryCount := 0
-	for retryCount < MaxOpenRetries {
-		device, err = findDevice()
-		if err != nil {
-			if err == errNoDeviceFound {
-				return FidoClient{}, err
-			}
-
-			retryCount++
-			time.Sleep(RetryDelayMS)
-			continue
-		}
-
-		return FidoClient{
-			Device:         device,
-			ChallengeNonce: challengeNonce,
-			AppId:          appId,
-			KeyHandle:      keyHandle,
-			StateToken:     stateToken,
-		}, nil
+	return NewFidoClientWithAuthenticator(&HardwareAuthenticator{}, challengeNonce, appId, keyHandle, stateToken)
+}
+
+// NewFidoClientWithAuthenticator returns a FidoClient signing with authenticator
+func NewFidoClientWithAuthenticator(authenticator Authenticator, challengeNonce, appId, keyHandle, stateToken string) (FidoClient, error) {
+	if authenticator == nil {
+		return FidoClient{}, errNoDeviceFound
 	}
 
-	return FidoClient{}, fmt.Errorf("failed to create client: %s. exceeded max retries of %d", err, MaxOpenRetries)
+	return FidoClient{
+		Authenticator:  authenticator,
+		ChallengeNonce: challengeNonce,
+		AppId:          appId,
+		KeyHandle:      keyHandle,
+		StateToken:     stateToken,
+	}, nil
 }
 
-func (d *FidoClient) ChallengeU2f() (*SignedAssertion, error) {
-
-	if d.Device == nil {
-		return nil, errors.New("No Device Found")
-	}
-	request := &u2fhost.AuthenticateRequest{
+func (d *FidoClient) request() *u2fhost.AuthenticateRequest {
+	return &u2fhost.AuthenticateRequest{
 		Challenge: d.ChallengeNonce,
 		// the appid is the only facet.
 		Facet:     "https://" + d.AppId,
@@ -76,70 +52,50 @@ func (d *FidoClient) ChallengeU2f() (*SignedAssertion, error) {
 		KeyHandle: d.KeyHandle,
 		WebAuthn:  true,
 	}
-	// do the change
-	prompted := false
-	timeout := time.After(time.Second * 25)
-	interval := time.NewTicker(time.Millisecond * 250)
-	var responsePayload *SignedAssertion
-
-	d.Device.Open()
-
-	defer func() {
-		d.Device.Close()
-	}()
-	defer interval.Stop()
-	for {
-		select {
-		case <-timeout:
-			return nil, errors.New("Failed to get authentication response after 25 seconds")
-		case <-interval.C:
-			response, err := d.Device.Authenticate(request)
-			if err == nil {
-				responsePayload = &SignedAssertion{
-					StateToken:        d.StateToken,
-					ClientData:        response.ClientData,
-					SignatureData:     response.SignatureData,
-					AuthenticatorData: response.AuthenticatorData,
-				}
-				fmt.Printf("  ==> Touch accepted. Proceeding with authentication\n")
-				return responsePayload, nil
-			}
-
-			switch t := err.(type) {
-			case *u2fhost.TestOfUserPresenceRequiredError:
-				if !prompted {
-					fmt.Printf("\nTouch the flashing U2F device to authenticate...\n")
-					prompted = true
-				}
-			default:
-				log.Debug("Got ErrType: ", t)
-				return responsePayload, err
-			}
-		}
-	}
+}
+
+func (d *FidoClient) signedAssertion(response *u2fhost.AuthenticateResponse) *SignedAssertion {
+	fmt.Fprintf(os.Stderr, "  ==> Touch accepted. Proceeding with authentication\n")
 
-	return responsePayload, nil
+	return &SignedAssertion{
+		StateToken:        d.StateToken,
+		ClientData:        response.ClientData,
+		SignatureData:     response.SignatureData,
+		AuthenticatorData: response.AuthenticatorData,
+	}
 }
 
-func findDevice() (u2fhost.Device, error) {
-	var err error
+// ChallengeU2f signs Okta's WebAuthn challenge and returns the assertion to
+// verify the factor with
+func (d *FidoClient) ChallengeU2f() (*SignedAssertion, error) {
 
-	allDevices := u2fhost.Devices()
-	if len(allDevices) == 0 {
-		return nil, errNoDeviceFound
+	if d.Authenticator == nil {
+		return nil, errors.New("No Device Found")
 	}
 
-	for i, device := range allDevices {
-		err = device.Open()
-		if err != nil {
-			log.Debugf("failed to open device: %s", err)
-			device.Close()
+	response, err := d.Authenticator.Authenticate(d.request())
+	if err != nil {
+		return nil, err
+	}
+	return d.signedAssertion(response), nil
+}
 
-			continue
-		}
+// ChallengeAnyU2f challenges all clients at once with authenticator, and
+// returns the index of the client whose security key was used along with its
+// assertion
+func ChallengeAnyU2f(authenticator Authenticator, clients []FidoClient) (int, *SignedAssertion, error) {
+	if len(clients) == 0 {
+		return 0, nil, errors.New("no security keys to challenge")
+	}
 
-		return allDevices[i], nil
+	reqs := make([]*u2fhost.AuthenticateRequest, len(clients))
+	for i := range clients {
+		reqs[i] = clients[i].request()
 	}
 
-	return nil, fmt.Errorf("failed to open fido U2F device: %s", err)
+	i, response, err := AuthenticateAny(authenticator, reqs)
+	if err != nil {
+		return 0, nil, err
+	}
+	return i, clients[i].signedAssertion(response), nil
 }
@@ -0,0 +1,118 @@
+package mfa
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/99designs/keyring"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	"github.com/stretchr/testify/assert"
+)
+
+// verifyAssertion checks sig against sha256(authenticatorData || sha256(clientData))
+func verifyAssertion(t *testing.T, publicKey *ecdsa.PublicKey, authenticatorData, clientData, sig []byte) {
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientHash[:]...))
+
+	var parsed ecdsaSignature
+	if _, err := asn1.Unmarshal(sig, &parsed); err != nil {
+		t.Fatalf("unmarshalling signature: %s", err)
+	}
+	if !ecdsa.Verify(publicKey, digest[:], parsed.R, parsed.S) {
+		t.Fatal("signature doesn't verify")
+	}
+}
+
+func TestFidoClientChallengeU2f(t *testing.T) {
+	authenticator := &SoftwareAuthenticator{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}
+	keyHandle, publicKey, err := authenticator.Credential()
+	if err != nil {
+		t.Fatalf("creating credential: %s", err)
+	}
+
+	client, err := NewFidoClientWithAuthenticator(authenticator, "nonce", "example.okta.com", keyHandle, "state-token")
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+
+	assertion, err := client.ChallengeU2f()
+	if err != nil {
+		t.Fatalf("challenging: %s", err)
+	}
+	assert.Equal(t, "state-token", assertion.StateToken)
+
+	clientJSON, err := base64.RawURLEncoding.DecodeString(assertion.ClientData)
+	if err != nil {
+		t.Fatalf("decoding client data: %s", err)
+	}
+	var clientData map[string]string
+	if err := json.Unmarshal(clientJSON, &clientData); err != nil {
+		t.Fatalf("unmarshalling client data: %s", err)
+	}
+	assert.Equal(t, map[string]string{
+		"type":      "webauthn.get",
+		"challenge": "nonce",
+		"origin":    "https://example.okta.com",
+	}, clientData)
+
+	authenticatorData, err := base64.StdEncoding.DecodeString(assertion.AuthenticatorData)
+	if err != nil {
+		t.Fatalf("decoding authenticator data: %s", err)
+	}
+	rpIDHash := sha256.Sum256([]byte("example.okta.com"))
+	assert.Equal(t, rpIDHash[:], authenticatorData[:32])
+	assert.Equal(t, []byte{0x01, 0, 0, 0, 1}, authenticatorData[32:])
+
+	sig, err := base64.StdEncoding.DecodeString(assertion.SignatureData)
+	if err != nil {
+		t.Fatalf("decoding signature: %s", err)
+	}
+	verifyAssertion(t, publicKey, authenticatorData, clientJSON, sig)
+}
+
+func TestFidoClientUnknownCredential(t *testing.T) {
+	authenticator := &SoftwareAuthenticator{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}
+	client, err := NewFidoClientWithAuthenticator(authenticator, "nonce", "example.okta.com", "c29tZW9uZS1lbHNl", "state-token")
+	if err != nil {
+		t.Fatalf("creating client: %s", err)
+	}
+
+	_, err = client.ChallengeU2f()
+	if _, ok := err.(*u2fhost.BadKeyHandleError); !ok {
+		t.Fatalf("expected BadKeyHandleError; got %v", err)
+	}
+}
+
+func TestChallengeAnyU2f(t *testing.T) {
+	authenticator := &SoftwareAuthenticator{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}
+	keyHandle, _, err := authenticator.Credential()
+	if err != nil {
+		t.Fatalf("creating credential: %s", err)
+	}
+
+	clients := []FidoClient{
+		{Authenticator: authenticator, ChallengeNonce: "nonce-1", AppId: "example.okta.com", KeyHandle: "c29tZW9uZS1lbHNl", StateToken: "state-token"},
+		{Authenticator: authenticator, ChallengeNonce: "nonce-2", AppId: "example.okta.com", KeyHandle: keyHandle, StateToken: "state-token"},
+	}
+
+	i, assertion, err := ChallengeAnyU2f(authenticator, clients)
+	if err != nil {
+		t.Fatalf("challenging: %s", err)
+	}
+	assert.Equal(t, 1, i)
+
+	clientJSON, err := base64.RawURLEncoding.DecodeString(assertion.ClientData)
+	if err != nil {
+		t.Fatalf("decoding client data: %s", err)
+	}
+	assert.Contains(t, string(clientJSON), `"challenge":"nonce-2"`)
+
+	_, _, err = ChallengeAnyU2f(authenticator, clients[:1])
+	if _, ok := err.(*u2fhost.BadKeyHandleError); !ok {
+		t.Fatalf("expected BadKeyHandleError; got %v", err)
+	}
+}
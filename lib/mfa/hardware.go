@@ -0,0 +1,214 @@
+package mfa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/karalabe/hid"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MaxOpenRetries = 10
+	RetryDelayMS   = 200 * time.Millisecond
+
+	// AuthenticateTimeout is how long the user has to touch their security key
+	AuthenticateTimeout = 25 * time.Second
+)
+
+var (
+	errNoDeviceFound = fmt.Errorf("no U2F devices found. device might not be plugged in")
+	errNoDeviceInfo  = fmt.Errorf("can't read the path and product of U2F devices, so they can't be selected by them")
+)
+
+// HardwareAuthenticator signs challenges with plugged-in HID security keys
+//
+// If Path or Product are set, only matching devices are used; otherwise every
+// security key is polled and the first one touched by the user wins.
+type HardwareAuthenticator struct {
+	// Path is the platform-specific HID path of the device to use
+	Path string
+	// Product selects devices whose product string contains it (case insensitive)
+	Product string
+}
+
+// HardwareDevice is a plugged-in security key
+type HardwareDevice struct {
+	// Info is empty if HasInfo is false
+	Info    hid.DeviceInfo
+	HasInfo bool
+	Device  u2fhost.Device
+}
+
+// HardwareDevices lists the plugged-in security keys
+//
+// The devices are enumerated once, by u2fhost, and the info of each is the
+// one it was created from, so that selecting a device by its info can't pick
+// another one.
+func HardwareDevices() []HardwareDevice {
+	devices := []HardwareDevice{}
+	for _, device := range u2fhost.Devices() {
+		info, ok := deviceInfo(device)
+		if !ok {
+			log.Debugf("couldn't get the HID info of a U2F device")
+		}
+		devices = append(devices, HardwareDevice{Info: info, HasInfo: ok, Device: device})
+	}
+	return devices
+}
+
+// deviceInfo returns the HID info a u2fhost device was created from.
+//
+// u2fhost doesn't expose it, nor a way to create a device from it, so it's
+// read from the device's unexported fields, whose path is fixed by the
+// vendored version: HidDevice.hidDevice, hid.HidDevice.device and
+// hid.RawHidDevice.Device, as checked by TestDeviceInfoVendored. If they
+// change, ok is false and the info is empty, so that selecting devices by path
+// or product fails rather than mixes them up.
+func deviceInfo(device interface{}) (info hid.DeviceInfo, ok bool) {
+	v := reflect.ValueOf(device)
+	for _, field := range []string{"hidDevice", "device", "Device"} {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return hid.DeviceInfo{}, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return hid.DeviceInfo{}, false
+		}
+		if v = v.FieldByName(field); !v.IsValid() {
+			return hid.DeviceInfo{}, false
+		}
+	}
+	if v.Type() != reflect.TypeOf(&info) || v.IsNil() {
+		return hid.DeviceInfo{}, false
+	}
+
+	// the fields are read one by one, as values read through unexported
+	// fields can't be copied whole
+	v = v.Elem()
+	infoValue := reflect.ValueOf(&info).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			infoValue.Field(i).SetString(field.String())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			infoValue.Field(i).SetUint(field.Uint())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			infoValue.Field(i).SetInt(field.Int())
+		}
+	}
+	return info, true
+}
+
+func (a *HardwareAuthenticator) matches(d HardwareDevice) bool {
+	if a.Path != "" && d.Info.Path != a.Path {
+		return false
+	}
+	if a.Product != "" && !strings.Contains(strings.ToLower(d.Info.Product), strings.ToLower(a.Product)) {
+		return false
+	}
+	return true
+}
+
+// open opens every matching device, retrying while devices are found but
+// none can be opened yet
+func (a *HardwareAuthenticator) open() ([]u2fhost.Device, error) {
+	var err error
+
+	for retryCount := 0; retryCount < MaxOpenRetries; retryCount++ {
+		var found bool
+		var openDevices []u2fhost.Device
+		for _, device := range HardwareDevices() {
+			if !device.HasInfo && (a.Path != "" || a.Product != "") {
+				return nil, errNoDeviceInfo
+			}
+			if !a.matches(device) {
+				log.Debugf("skipping device %s (%s)", device.Info.Path, device.Info.Product)
+				continue
+			}
+			found = true
+			if err = device.Device.Open(); err != nil {
+				log.Debugf("failed to open device %s: %s", device.Info.Path, err)
+				device.Device.Close()
+				continue
+			}
+			log.Debugf("using device %s (%s)", device.Info.Path, device.Info.Product)
+			openDevices = append(openDevices, device.Device)
+		}
+		if !found {
+			return nil, errNoDeviceFound
+		}
+		if len(openDevices) > 0 {
+			return openDevices, nil
+		}
+		time.Sleep(RetryDelayMS)
+	}
+
+	return nil, fmt.Errorf("failed to open fido U2F device: %s. exceeded max retries of %d", err, MaxOpenRetries)
+}
+
+// Authenticate polls the matching devices until the user touches one of them
+//
+// It returns *u2fhost.BadKeyHandleError if no device knows the key handle.
+func (a *HardwareAuthenticator) Authenticate(req *u2fhost.AuthenticateRequest) (*u2fhost.AuthenticateResponse, error) {
+	_, response, err := a.AuthenticateAny([]*u2fhost.AuthenticateRequest{req})
+	return response, err
+}
+
+// AuthenticateAny polls the matching devices with every request until the user
+// touches one of them, and returns the index of the request that was signed
+//
+// It returns *u2fhost.BadKeyHandleError if no device knows any key handle.
+func (a *HardwareAuthenticator) AuthenticateAny(reqs []*u2fhost.AuthenticateRequest) (int, *u2fhost.AuthenticateResponse, error) {
+	devices, err := a.open()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		for _, device := range devices {
+			device.Close()
+		}
+	}()
+
+	prompted := false
+	timeout := time.After(AuthenticateTimeout)
+	interval := time.NewTicker(time.Millisecond * 250)
+	defer interval.Stop()
+	for {
+		select {
+		case <-timeout:
+			return 0, nil, errors.New("Failed to get authentication response after 25 seconds")
+		case <-interval.C:
+			unknownKeyHandle := 0
+			for _, device := range devices {
+				for i, req := range reqs {
+					response, err := device.Authenticate(req)
+					switch t := err.(type) {
+					case nil:
+						return i, response, nil
+					case *u2fhost.TestOfUserPresenceRequiredError:
+						if !prompted {
+							fmt.Fprintf(os.Stderr, "\nTouch the flashing U2F device to authenticate...\n")
+							prompted = true
+						}
+					case *u2fhost.BadKeyHandleError:
+						unknownKeyHandle++
+					default:
+						log.Debug("Got ErrType: ", t)
+						return 0, nil, err
+					}
+				}
+			}
+			if unknownKeyHandle == len(devices)*len(reqs) {
+				return 0, nil, &u2fhost.BadKeyHandleError{}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package mfa
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	"github.com/karalabe/hid"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	u2fhid "github.com/marshallbrekka/go-u2fhost/hid"
+	"github.com/stretchr/testify/assert"
+)
+
+// these mirror the types of u2fhost, whose devices can't be created without
+// hardware
+type fakeRawHidDevice struct {
+	Device *hid.DeviceInfo
+}
+
+type fakeBaseDevice interface{}
+
+type fakeHidDevice struct {
+	device fakeBaseDevice
+}
+
+type fakeHidDeviceInterface interface{}
+
+type fakeU2FDevice struct {
+	hidDevice fakeHidDeviceInterface
+}
+
+func TestDeviceInfo(t *testing.T) {
+	expected := hid.DeviceInfo{
+		Path:      "/dev/hidraw3",
+		VendorID:  0x1050,
+		ProductID: 0x0407,
+		Product:   "YubiKey OTP+FIDO+CCID",
+		UsagePage: 0xf1d0,
+		Usage:     1,
+		Interface: 1,
+	}
+	info := expected
+	device := &fakeU2FDevice{hidDevice: &fakeHidDevice{device: &fakeRawHidDevice{Device: &info}}}
+
+	actual, ok := deviceInfo(device)
+	assert.True(t, ok)
+	assert.Equal(t, expected, actual)
+
+	// other layouts give no info, rather than wrong info
+	for _, other := range []interface{}{
+		nil,
+		&fakeU2FDevice{},
+		&fakeU2FDevice{hidDevice: &fakeHidDevice{device: &fakeRawHidDevice{}}},
+		&fakeU2FDevice{hidDevice: &fakeHidDevice{device: &struct{ Device hid.DeviceInfo }{info}}},
+		&fakeHidDevice{device: &fakeRawHidDevice{Device: &info}},
+	} {
+		actual, ok := deviceInfo(other)
+		assert.False(t, ok, "%#v", other)
+		assert.Equal(t, hid.DeviceInfo{}, actual)
+	}
+}
+
+// setUnexported sets the unexported field name of the struct v points to
+func setUnexported(t *testing.T, v interface{}, name string, x interface{}) {
+	field := reflect.ValueOf(v).Elem().FieldByName(name)
+	if !field.IsValid() {
+		t.Fatalf("%T has no field %s", v, name)
+	}
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(reflect.ValueOf(x))
+}
+
+// TestDeviceInfoVendored checks that deviceInfo gets the info of devices of
+// the vendored u2fhost, whose unexported fields it reads, so that updating
+// u2fhost can't silently break selecting devices by path or product
+func TestDeviceInfoVendored(t *testing.T) {
+	expected := hid.DeviceInfo{
+		Path:      "/dev/hidraw3",
+		VendorID:  0x1050,
+		ProductID: 0x0407,
+		Product:   "YubiKey OTP+FIDO+CCID",
+		UsagePage: 0xf1d0,
+		Usage:     1,
+		Interface: 1,
+	}
+	info := expected
+
+	// built like u2fhost.Devices() does, which needs hardware
+	hidDevice := &u2fhid.HidDevice{}
+	setUnexported(t, hidDevice, "device", &u2fhid.RawHidDevice{Device: &info})
+	device := &u2fhost.HidDevice{}
+	setUnexported(t, device, "hidDevice", hidDevice)
+
+	actual, ok := deviceInfo(device)
+	assert.True(t, ok, "deviceInfo doesn't find the info of u2fhost devices")
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,257 @@
+package mfa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/99designs/keyring"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// SoftwareKeyringItemKey is the keyring item holding the software authenticator's key
+const SoftwareKeyringItemKey = "fido-software-authenticator"
+
+// SoftwareAuthenticator is a security key implemented in software, whose
+// private key is stored in the keyring
+//
+// It is meant for CI and integration tests: enroll it with Okta or Duo as a
+// U2F security key (see Register, and the software-key command), then select
+// it with the "software" FIDO device. The key is created on first use.
+type SoftwareAuthenticator struct {
+	Keyring keyring.Keyring
+}
+
+type softwareKey struct {
+	KeyHandle  string
+	PrivateKey []byte
+	Counter    uint32
+}
+
+// same as u2fhost's clientData
+type softwareClientData struct {
+	Typ       string `json:"typ,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func (a *SoftwareAuthenticator) load() (*softwareKey, *ecdsa.PrivateKey, error) {
+	item, err := a.Keyring.Get(SoftwareKeyringItemKey)
+	if err == keyring.ErrKeyNotFound {
+		return a.create()
+	} else if err != nil {
+		return nil, nil, xerrors.Errorf("failed Keyring.Get(%q): %w", SoftwareKeyringItemKey, err)
+	}
+
+	var key softwareKey
+	if err := json.Unmarshal(item.Data, &key); err != nil {
+		return nil, nil, xerrors.Errorf("failed unmarshal for %q: %w", SoftwareKeyringItemKey, err)
+	}
+	privateKey, err := x509.ParseECPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("parsing software authenticator key: %w", err)
+	}
+	return &key, privateKey, nil
+}
+
+func (a *SoftwareAuthenticator) create() (*softwareKey, *ecdsa.PrivateKey, error) {
+	log.Debugf("creating software authenticator key")
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyHandle := make([]byte, 32)
+	if _, err := rand.Read(keyHandle); err != nil {
+		return nil, nil, err
+	}
+
+	key := &softwareKey{
+		KeyHandle:  base64.RawURLEncoding.EncodeToString(keyHandle),
+		PrivateKey: der,
+	}
+	if err := a.save(key); err != nil {
+		return nil, nil, err
+	}
+	return key, privateKey, nil
+}
+
+func (a *SoftwareAuthenticator) save(key *softwareKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	item := keyring.Item{
+		Key:                         SoftwareKeyringItemKey,
+		Label:                       "aws-okta software security key",
+		Data:                        data,
+		KeychainNotTrustApplication: false,
+	}
+	if err := a.Keyring.Set(item); err != nil {
+		return xerrors.Errorf("writing %q: %w", SoftwareKeyringItemKey, err)
+	}
+	return nil
+}
+
+// Credential returns the key handle (aka credential ID) and public key to
+// enroll the software authenticator with
+func (a *SoftwareAuthenticator) Credential() (string, *ecdsa.PublicKey, error) {
+	key, privateKey, err := a.load()
+	if err != nil {
+		return "", nil, err
+	}
+	return key.KeyHandle, &privateKey.PublicKey, nil
+}
+
+// Register returns the U2F registration of the software authenticator's key
+// for req, to enroll it with, e.g., Okta's u2f factor activation
+//
+// The attestation certificate is self-signed with the key itself, as there is
+// no manufacturer to vouch for it.
+func (a *SoftwareAuthenticator) Register(req *u2fhost.RegisterRequest) (*u2fhost.RegisterResponse, error) {
+	key, privateKey, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	keyHandle, err := base64.RawURLEncoding.DecodeString(key.KeyHandle)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding key handle: %w", err)
+	}
+
+	clientJSON, err := json.Marshal(softwareClientData{
+		Typ:       "navigator.id.finishEnrollment",
+		Challenge: req.Challenge,
+		Origin:    req.Facet,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "aws-okta software security key"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(20, 0, 0),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, xerrors.Errorf("creating attestation certificate: %w", err)
+	}
+
+	// https://fidoalliance.org/specs/fido-u2f-v1.0-nfc-bt-amendment-20150514/fido-u2f-raw-message-formats.html#registration-response-message-success
+	publicKey := elliptic.Marshal(elliptic.P256(), privateKey.PublicKey.X, privateKey.PublicKey.Y)
+	appIDHash := sha256.Sum256([]byte(req.AppId))
+	clientHash := sha256.Sum256(clientJSON)
+	signed := append([]byte{0x00}, appIDHash[:]...)
+	signed = append(signed, clientHash[:]...)
+	signed = append(signed, keyHandle...)
+	signed = append(signed, publicKey...)
+	digest := sha256.Sum256(signed)
+
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := asn1.Marshal(ecdsaSignature{r, s})
+	if err != nil {
+		return nil, err
+	}
+
+	data := append([]byte{0x05}, publicKey...)
+	data = append(data, byte(len(keyHandle)))
+	data = append(data, keyHandle...)
+	data = append(data, cert...)
+	data = append(data, signature...)
+
+	return &u2fhost.RegisterResponse{
+		RegistrationData: base64.RawURLEncoding.EncodeToString(data),
+		ClientData:       base64.RawURLEncoding.EncodeToString(clientJSON),
+	}, nil
+}
+
+// Authenticate signs req if it is for the software authenticator's key handle
+//
+// Responses are formatted exactly like go-u2fhost's, so they can be used
+// wherever a hardware key's response is.
+func (a *SoftwareAuthenticator) Authenticate(req *u2fhost.AuthenticateRequest) (*u2fhost.AuthenticateResponse, error) {
+	key, privateKey, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(req.KeyHandle, "=") != key.KeyHandle {
+		return nil, &u2fhost.BadKeyHandleError{}
+	}
+
+	clientData := softwareClientData{
+		Challenge: req.Challenge,
+		Origin:    req.Facet,
+	}
+	if req.WebAuthn {
+		clientData.Type = "webauthn.get"
+	} else {
+		clientData.Typ = "navigator.id.getAssertion"
+	}
+	clientJSON, err := json.Marshal(clientData)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Counter++
+	if err := a.save(key); err != nil {
+		return nil, err
+	}
+
+	// https://fidoalliance.org/specs/fido-u2f-v1.0-nfc-bt-amendment-20150514/fido-u2f-raw-message-formats.html#authentication-response-message-success
+	// user presence flag, then the big endian counter
+	presence := make([]byte, 5)
+	presence[0] = 0x01
+	binary.BigEndian.PutUint32(presence[1:], key.Counter)
+
+	appIDHash := sha256.Sum256([]byte(req.AppId))
+	clientHash := sha256.Sum256(clientJSON)
+	authenticatorData := append(appIDHash[:], presence...)
+	digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientHash[:]...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := asn1.Marshal(ecdsaSignature{r, s})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &u2fhost.AuthenticateResponse{
+		KeyHandle:  req.KeyHandle,
+		ClientData: base64.RawURLEncoding.EncodeToString(clientJSON),
+	}
+	if req.WebAuthn {
+		response.SignatureData = base64.StdEncoding.EncodeToString(signature)
+		response.AuthenticatorData = base64.StdEncoding.EncodeToString(authenticatorData)
+	} else {
+		response.SignatureData = base64.RawURLEncoding.EncodeToString(append(presence, signature...))
+	}
+	return response, nil
+}
@@ -0,0 +1,141 @@
+package mfa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"testing"
+
+	"github.com/99designs/keyring"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftwareAuthenticatorU2F(t *testing.T) {
+	kr := keyring.NewArrayKeyring([]keyring.Item{})
+	keyHandle, publicKey, err := (&SoftwareAuthenticator{Keyring: kr}).Credential()
+	if err != nil {
+		t.Fatalf("creating credential: %s", err)
+	}
+
+	// a fresh authenticator on the same keyring must reuse the stored key
+	authenticator := &SoftwareAuthenticator{Keyring: kr}
+	req := &u2fhost.AuthenticateRequest{
+		Challenge: "challenge",
+		AppId:     "https://api-1234.duosecurity.com",
+		Facet:     "https://api-1234.duosecurity.com",
+		KeyHandle: keyHandle,
+	}
+
+	for counter := byte(1); counter <= 2; counter++ {
+		response, err := authenticator.Authenticate(req)
+		if err != nil {
+			t.Fatalf("authenticating: %s", err)
+		}
+		assert.Equal(t, keyHandle, response.KeyHandle)
+		assert.Empty(t, response.AuthenticatorData)
+
+		clientJSON, err := base64.RawURLEncoding.DecodeString(response.ClientData)
+		if err != nil {
+			t.Fatalf("decoding client data: %s", err)
+		}
+		assert.Contains(t, string(clientJSON), `"typ":"navigator.id.getAssertion"`)
+
+		signatureData, err := base64.RawURLEncoding.DecodeString(response.SignatureData)
+		if err != nil {
+			t.Fatalf("decoding signature data: %s", err)
+		}
+		presence := signatureData[:5]
+		assert.Equal(t, []byte{0x01, 0, 0, 0, counter}, presence)
+
+		appIDHash := sha256.Sum256([]byte(req.AppId))
+		verifyAssertion(t, publicKey, append(appIDHash[:], presence...), clientJSON, signatureData[5:])
+	}
+}
+
+func TestSoftwareAuthenticatorRegister(t *testing.T) {
+	authenticator := &SoftwareAuthenticator{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}
+	keyHandle, publicKey, err := authenticator.Credential()
+	if err != nil {
+		t.Fatalf("creating credential: %s", err)
+	}
+
+	req := &u2fhost.RegisterRequest{
+		Challenge: "challenge",
+		AppId:     "https://example.okta.com",
+		Facet:     "https://example.okta.com",
+	}
+	response, err := authenticator.Register(req)
+	if err != nil {
+		t.Fatalf("registering: %s", err)
+	}
+
+	clientJSON, err := base64.RawURLEncoding.DecodeString(response.ClientData)
+	if err != nil {
+		t.Fatalf("decoding client data: %s", err)
+	}
+	assert.Contains(t, string(clientJSON), `"typ":"navigator.id.finishEnrollment"`)
+	assert.Contains(t, string(clientJSON), `"challenge":"challenge"`)
+
+	data, err := base64.RawURLEncoding.DecodeString(response.RegistrationData)
+	if err != nil {
+		t.Fatalf("decoding registration data: %s", err)
+	}
+	assert.Equal(t, byte(0x05), data[0])
+	rawPublicKey := data[1:66]
+	assert.Equal(t, elliptic.Marshal(elliptic.P256(), publicKey.X, publicKey.Y), rawPublicKey)
+	rawKeyHandle := data[67 : 67+int(data[66])]
+	assert.Equal(t, keyHandle, base64.RawURLEncoding.EncodeToString(rawKeyHandle))
+
+	var cert asn1.RawValue
+	signature, err := asn1.Unmarshal(data[67+len(rawKeyHandle):], &cert)
+	if err != nil {
+		t.Fatalf("unmarshalling certificate: %s", err)
+	}
+	parsedCert, err := x509.ParseCertificate(cert.FullBytes)
+	if err != nil {
+		t.Fatalf("parsing certificate: %s", err)
+	}
+	assert.Equal(t, publicKey, parsedCert.PublicKey)
+
+	appIDHash := sha256.Sum256([]byte(req.AppId))
+	clientHash := sha256.Sum256(clientJSON)
+	signed := append([]byte{0x00}, appIDHash[:]...)
+	signed = append(signed, clientHash[:]...)
+	signed = append(signed, rawKeyHandle...)
+	signed = append(signed, rawPublicKey...)
+	digest := sha256.Sum256(signed)
+
+	var parsed ecdsaSignature
+	if _, err := asn1.Unmarshal(signature, &parsed); err != nil {
+		t.Fatalf("unmarshalling signature: %s", err)
+	}
+	assert.True(t, ecdsa.Verify(publicKey, digest[:], parsed.R, parsed.S), "signature doesn't verify")
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	kr := keyring.NewArrayKeyring([]keyring.Item{})
+
+	for spec, expected := range map[string]Authenticator{
+		"":                 &HardwareAuthenticator{},
+		"path:/dev/hidraw": &HardwareAuthenticator{Path: "/dev/hidraw"},
+		"product:yubikey":  &HardwareAuthenticator{Product: "yubikey"},
+		"software":         &SoftwareAuthenticator{Keyring: kr},
+	} {
+		a, err := NewAuthenticator(spec, kr)
+		if err != nil {
+			t.Fatalf("NewAuthenticator(%q): %s", spec, err)
+		}
+		assert.Equal(t, expected, a)
+	}
+
+	if _, err := NewAuthenticator("usb", kr); err == nil {
+		t.Error("expected an error for an invalid spec")
+	}
+	if _, err := NewAuthenticator("software", nil); err == nil {
+		t.Error("expected an error for the software authenticator without a keyring")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/99designs/keyring"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
@@ -53,12 +55,40 @@ type OktaClient struct {
 	BaseURL         *url.URL
 	Domain          string
 	MFAConfig       MFAConfig
+	// Authenticator signs FIDO challenges; if nil, it is picked from
+	// MFAConfig.FidoDevice
+	Authenticator mfa.Authenticator
+	// SAMLValidation, if set, is used to validate SAML assertions before
+	// they are sent to STS
+	SAMLValidation *saml.ValidationOptions
+	// SAMLCache, if set, keeps SAML assertions so they can be used for
+	// several roles
+	SAMLCache *SAMLAssertionCache
+	// SessionAttributes are set by AuthenticateProfile3 from the SAML
+	// assertion it used
+	SessionAttributes saml.SessionAttributes
+	// AccountAliases name the accounts when prompting for a role. Aliases
+	// from the AWS sign-in page are added to it.
+	AccountAliases AccountAliases
+	// STSRegionalEndpoint is whether to use the regional STS endpoint of
+	// the region passed to AuthenticateProfile3; unset means regional
+	STSRegionalEndpoint endpoints.STSRegionalEndpoint
+	// SessionPolicy, if set, scopes down the credentials of the role
+	// assumed with SAML
+	SessionPolicy SessionPolicy
+	// SessionDurationDefaulted is set when the user didn't choose the
+	// session duration; it is then shortened to the SessionDuration
+	// attribute without a warning
+	SessionDurationDefaulted bool
 }
 
 type MFAConfig struct {
 	Provider   string // Which MFA provider to use when presented with an MFA challenge
 	FactorType string // Which of the factor types of the MFA provider to use
 	DuoDevice  string // Which DUO device to use for DUO MFA
+	FidoDevice string // Which security key to use for FIDO and Duo U2F/WebAuthn; see mfa.NewAuthenticator
+	// Challenge every enrolled FIDO webauthn/u2f factor at once, and use whichever key is touched
+	AllSecurityKeys bool
 }
 
 type SAMLAssertion struct {
@@ -220,12 +250,86 @@ func (o *OktaClient) AuthenticateProfileWithRegion(profileARN string, duration t
 }
 
 func (o *OktaClient) AuthenticateProfile3(profileARN string, duration time.Duration, region string) (sts.Credentials, OktaCookies, error) {
+	var oc OktaCookies
+
+	cacheKey := samlAssertionCacheKey(o.Domain, o.OktaAwsSAMLUrl, o.Username)
+	var assertion *SAMLAssertion
+	cached := false
+	if o.SAMLCache != nil {
+		assertion, cached = o.SAMLCache.Get(cacheKey)
+	}
+	if !cached {
+		var err error
+		if assertion, err = o.getSAMLAssertion(); err != nil {
+			return sts.Credentials{}, oc, err
+		}
+		if o.SAMLCache != nil {
+			if err := o.SAMLCache.Put(cacheKey, assertion); err != nil {
+				log.Debugf("Failed to cache SAML assertion: %s", err)
+			}
+		}
+	}
+
+	creds, err := o.assumeRoleWithSAML(assertion, profileARN, duration, region)
+	if cached && isSAMLAssertionRejected(err) {
+		log.Debugf("Cached SAML assertion was rejected, getting a new one: %s", err)
+		o.SAMLCache.Delete(cacheKey)
+		if assertion, err = o.getSAMLAssertion(); err != nil {
+			return sts.Credentials{}, oc, err
+		}
+		if err := o.SAMLCache.Put(cacheKey, assertion); err != nil {
+			log.Debugf("Failed to cache SAML assertion: %s", err)
+		}
+		creds, err = o.assumeRoleWithSAML(assertion, profileARN, duration, region)
+	}
+	if err != nil {
+		return sts.Credentials{}, oc, err
+	}
+
+	return creds, o.cookies(), nil
+}
+
+// GetSAMLAssertion gets a new SAML assertion for the AWS app, authenticating
+// if needed, and caches it for AuthenticateProfile3
+func (o *OktaClient) GetSAMLAssertion() (*SAMLAssertion, OktaCookies, error) {
+	assertion, err := o.getSAMLAssertion()
+	if err != nil {
+		return nil, OktaCookies{}, err
+	}
+	if o.SAMLCache != nil {
+		cacheKey := samlAssertionCacheKey(o.Domain, o.OktaAwsSAMLUrl, o.Username)
+		if err := o.SAMLCache.Put(cacheKey, assertion); err != nil {
+			log.Debugf("Failed to cache SAML assertion: %s", err)
+		}
+	}
+	return assertion, o.cookies(), nil
+}
+
+func (o *OktaClient) cookies() OktaCookies {
+	var oc OktaCookies
+	cookies := o.CookieJar.Cookies(o.BaseURL)
+	for _, cookie := range cookies {
+		if cookie.Name == "sid" {
+			oc.Session = cookie.Value
+		}
+		if cookie.Name == "DT" {
+			oc.DeviceToken = cookie.Value
+		}
+	}
+	return oc
+}
+
+// getSAMLAssertion gets a SAML assertion for the AWS app from Okta,
+// authenticating if the session cookie can't be reused
+func (o *OktaClient) getSAMLAssertion() (*SAMLAssertion, error) {
 
 	// Attempt to reuse session cookie
 	var assertion SAMLAssertion
-	var oc OktaCookies
 
 	err := o.Get("GET", o.OktaAwsSAMLUrl, nil, &assertion, "saml")
+	if stepUpErr, ok := err.(*StepUpRequiredError); ok {
+		err = o.stepUp(stepUpErr, &assertion)
+	}
 	if err != nil {
 		log.Debug("Failed to reuse session token, starting flow from start")
 
@@ -241,62 +345,142 @@ func (o *OktaClient) AuthenticateProfile3(profileARN string, duration time.Durat
 		err := o.Get("GET", o.OktaAwsSAMLUrl, nil, &assertion, "saml")
 
 		if err := o.AuthenticateUser(); err != nil {
-			return sts.Credentials{}, oc, err
+			return nil, err
 		}
 
 		// Step 3 : Get SAML Assertion and retrieve IAM Roles
 		log.Debug("Step: 3")
-		if err = o.Get("GET", o.OktaAwsSAMLUrl+"?onetimetoken="+o.UserAuth.SessionToken,
-			nil, &assertion, "saml"); err != nil {
-			return sts.Credentials{}, oc, err
+		err = o.Get("GET", o.OktaAwsSAMLUrl+"?onetimetoken="+o.UserAuth.SessionToken,
+			nil, &assertion, "saml")
+		if stepUpErr, ok := err.(*StepUpRequiredError); ok {
+			err = o.stepUp(stepUpErr, &assertion)
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	principal, role, err := GetRoleFromSAML(assertion.Resp, profileARN)
+	if o.SAMLValidation != nil {
+		if err := ValidateSAML(&assertion, *o.SAMLValidation); err != nil {
+			return nil, err
+		}
+	}
+
+	return &assertion, nil
+}
+
+// addSigninPageAliases adds the aliases of the accounts of assertion's roles
+// to o.AccountAliases
+func (o *OktaClient) addSigninPageAliases(assertion *SAMLAssertion) {
+	if o.AccountAliases == nil {
+		o.AccountAliases = AccountAliases{}
+	}
+	AddSigninPageAliases(assertion, o.AccountAliases)
+}
+
+// assumeRoleWithSAML assumes profileARN, or the role picked by the user, with
+// assertion. A duration of 0 requests the assertion's SessionDuration.
+func (o *OktaClient) assumeRoleWithSAML(assertion *SAMLAssertion, profileARN string, duration time.Duration, region string) (sts.Credentials, error) {
+	roles, err := GetAssumableRolesFromSAML(assertion.Resp)
 	if err != nil {
-		return sts.Credentials{}, oc, err
+		return sts.Credentials{}, err
+	}
+	var assumableRole saml.AssumableRole
+	if profileARN == "" && len(roles) > 1 {
+		// name the accounts in the role prompt
+		o.addSigninPageAliases(assertion)
+
+		assumableRole, err = PickRole(roles, o.AccountAliases, loadRecentRole(o.OktaAwsSAMLUrl))
+		if err != nil {
+			return sts.Credentials{}, err
+		}
+		if o.OktaAwsSAMLUrl != "" {
+			saveRecentRole(o.OktaAwsSAMLUrl, assumableRole.Role)
+		}
+	} else {
+		if patternNeedsAliases(profileARN) {
+			o.addSigninPageAliases(assertion)
+		}
+		assumableRole, err = GetRoleWithAliases(roles, profileARN, o.AccountAliases)
+		if err != nil {
+			return sts.Credentials{}, err
+		}
 	}
+	principal, role := assumableRole.Principal, assumableRole.Role
 
 	// Step 4 : Assume Role with SAML
 	log.Debug("Step 4: Assume Role with SAML")
-	var samlSess *session.Session
-	if region != "" {
-		log.Debugf("Using region: %s\n", region)
-		conf := &aws.Config{
-			Region:              aws.String(region),
-			STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
-		}
-		samlSess = session.Must(session.NewSession(conf))
-	} else {
-		samlSess = session.Must(session.NewSession())
+	conf, err := stsConfig(role, region, o.STSRegionalEndpoint)
+	if err != nil {
+		return sts.Credentials{}, err
+	}
+	if conf.Region != nil {
+		log.Debugf("Using region: %s\n", *conf.Region)
 	}
-	svc := sts.New(samlSess)
+	svc := sts.New(session.Must(session.NewSession(conf)))
 
 	samlParams := &sts.AssumeRoleWithSAMLInput{
-		PrincipalArn:    aws.String(principal),
-		RoleArn:         aws.String(role),
-		SAMLAssertion:   aws.String(string(assertion.RawData)),
-		DurationSeconds: aws.Int64(int64(duration.Seconds())),
+		PrincipalArn:  aws.String(principal),
+		RoleArn:       aws.String(role),
+		SAMLAssertion: aws.String(string(assertion.RawData)),
 	}
+	requested := duration
+	if duration = GetSessionDurationFromSAML(assertion.Resp, requested); duration < requested && !o.SessionDurationDefaulted {
+		log.Warnf("Session duration %s is longer than the %s allowed by Okta; using %s", requested, duration, duration)
+	}
+	if duration != 0 {
+		samlParams.DurationSeconds = aws.Int64(int64(duration.Seconds()))
+	}
+	if o.SessionPolicy.Policy != "" {
+		samlParams.Policy = aws.String(o.SessionPolicy.Policy)
+	}
+	samlParams.PolicyArns = o.SessionPolicy.policyDescriptors()
 
 	samlResp, err := svc.AssumeRoleWithSAML(samlParams)
 	if err != nil {
 		log.WithField("role", role).Errorf(
 			"error assuming role with SAML: %s", err.Error())
-		return sts.Credentials{}, oc, err
+		return sts.Credentials{}, err
 	}
 
-	cookies := o.CookieJar.Cookies(o.BaseURL)
-	for _, cookie := range cookies {
-		if cookie.Name == "sid" {
-			oc.Session = cookie.Value
-		}
-		if cookie.Name == "DT" {
-			oc.DeviceToken = cookie.Value
+	o.SessionAttributes = assertion.Resp.SessionAttributes()
+	logSessionAttributes(o.SessionAttributes)
+
+	return *samlResp.Credentials, nil
+}
+
+func logSessionAttributes(attrs saml.SessionAttributes) {
+	if attrs.RoleSessionName != "" {
+		log.Debugf("SAML RoleSessionName: %s", attrs.RoleSessionName)
+	}
+	if attrs.SourceIdentity != "" {
+		log.Debugf("SAML SourceIdentity: %s", attrs.SourceIdentity)
+	}
+	for key, value := range attrs.PrincipalTags {
+		log.Debugf("SAML PrincipalTag: %s=%s", key, value)
+	}
+	if len(attrs.TransitiveTagKeys) > 0 {
+		log.Debugf("SAML TransitiveTagKeys: %s", strings.Join(attrs.TransitiveTagKeys, ", "))
+	}
+}
+
+// isSAMLAssertionRejected reports whether STS refused an assertion itself,
+// rather than the role it was asked for
+func isSAMLAssertionRejected(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case sts.ErrCodeExpiredTokenException, sts.ErrCodeInvalidIdentityTokenException:
+			return true
 		}
 	}
+	return false
+}
 
-	return *samlResp.Credentials, oc, nil
+func (o *OktaClient) authenticator() (mfa.Authenticator, error) {
+	if o.Authenticator != nil {
+		return o.Authenticator, nil
+	}
+	return mfa.NewAuthenticator(o.MFAConfig.FidoDevice, nil)
 }
 
 func selectMFADeviceFromConfig(o *OktaClient) (*OktaUserAuthnFactor, error) {
@@ -406,13 +590,18 @@ func (o *OktaClient) postChallenge(payload []byte, oktaFactorProvider string, ok
 		if oktaFactorProvider == "DUO" {
 			// Contact the Duo to initiate Push notification
 			if f.Embedded.Verification.Host != "" {
+				authenticator, err := o.authenticator()
+				if err != nil {
+					return err
+				}
 				o.DuoClient = &DuoClient{
-					Host:       f.Embedded.Verification.Host,
-					Signature:  f.Embedded.Verification.Signature,
-					Callback:   f.Embedded.Verification.Links.Complete.Href,
-					Device:     o.MFAConfig.DuoDevice,
-					StateToken: o.UserAuth.StateToken,
-					FactorID:   f.Id,
+					Host:          f.Embedded.Verification.Host,
+					Signature:     f.Embedded.Verification.Signature,
+					Callback:      f.Embedded.Verification.Links.Complete.Href,
+					Device:        o.MFAConfig.DuoDevice,
+					StateToken:    o.UserAuth.StateToken,
+					FactorID:      f.Id,
+					Authenticator: authenticator,
 				}
 
 				log.Debugf("Host:%s\nSignature:%s\nStateToken:%s\n",
@@ -437,7 +626,12 @@ func (o *OktaClient) postChallenge(payload []byte, oktaFactorProvider string, ok
 			log.Debug("  CredentialId: ", f.Profile.CredentialId)
 			log.Debug("  StateToken: ", o.UserAuth.StateToken)
 
-			fidoClient, err := mfa.NewFidoClient(f.Embedded.Challenge.Challenge,
+			authenticator, err := o.authenticator()
+			if err != nil {
+				return err
+			}
+			fidoClient, err := mfa.NewFidoClientWithAuthenticator(authenticator,
+				f.Embedded.Challenge.Challenge,
 				o.Domain,
 				f.Profile.CredentialId,
 				o.UserAuth.StateToken)
@@ -483,6 +677,10 @@ func (o *OktaClient) challengeMFA() (err error) {
 	var payload []byte
 	var oktaFactorType string
 
+	if keyFactors := o.securityKeyFactors(); o.MFAConfig.AllSecurityKeys && len(keyFactors) > 1 {
+		return o.challengeSecurityKeys(keyFactors)
+	}
+
 	factor, err := o.selectMFADevice()
 	if err != nil {
 		log.Debug("Failed to select MFA device")
@@ -521,6 +719,93 @@ func (o *OktaClient) challengeMFA() (err error) {
 	return
 }
 
+// securityKeyFactors returns the user's FIDO webauthn and u2f factors; Okta
+// lists every enrolled security key as a separate factor
+func (o *OktaClient) securityKeyFactors() []OktaUserAuthnFactor {
+	var factors []OktaUserAuthnFactor
+	for _, f := range o.UserAuth.Embedded.Factors {
+		if f.Provider == "FIDO" && (f.FactorType == "webauthn" || f.FactorType == "u2f") {
+			factors = append(factors, f)
+		}
+	}
+	return factors
+}
+
+// challengeSecurityKeys starts a challenge for each security key factor, waits
+// for the user to touch any of their keys, and verifies the matching factor,
+// like a browser does. The signature is posted with the state token of the
+// touched factor's challenge.
+func (o *OktaClient) challengeSecurityKeys(factors []OktaUserAuthnFactor) error {
+	authenticator, err := o.authenticator()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(OktaStateToken{
+		StateToken: o.UserAuth.StateToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	clients := make([]mfa.FidoClient, len(factors))
+	for i, f := range factors {
+		clients[i], err = o.challengeSecurityKey(authenticator, f, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	i, signedAssertion, err := mfa.ChallengeAnyU2f(authenticator, clients)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Security key factor %s was used", factors[i].Id)
+
+	payload, err = json.Marshal(signedAssertion)
+	if err != nil {
+		return err
+	}
+	err = o.Get("POST", "api/v1/authn/factors/"+factors[i].Id+"/verify",
+		payload, &o.UserAuth, "json",
+	)
+	if err != nil {
+		return fmt.Errorf("Failed authn verification for okta. Err: %s", err)
+	}
+	if o.UserAuth.Status != "SUCCESS" {
+		return fmt.Errorf("Failed authn verification for okta. Status: %s", o.UserAuth.Status)
+	}
+	return nil
+}
+
+// challengeSecurityKey starts a challenge for a security key factor, and
+// returns a client to sign it
+func (o *OktaClient) challengeSecurityKey(authenticator mfa.Authenticator, f OktaUserAuthnFactor, payload []byte) (mfa.FidoClient, error) {
+	var challenge OktaUserAuthn
+	err := o.Get("POST", "api/v1/authn/factors/"+f.Id+"/verify?rememberDevice=true",
+		payload, &challenge, "json",
+	)
+	if err != nil {
+		return mfa.FidoClient{}, fmt.Errorf("Failed to challenge security key %s. Err: %s", f.Id, err)
+	}
+
+	credentialId := challenge.Embedded.Factor.Profile.CredentialId
+	if credentialId == "" {
+		credentialId = f.Profile.CredentialId
+	}
+	stateToken := challenge.StateToken
+	if stateToken == "" {
+		stateToken = o.UserAuth.StateToken
+	}
+	log.Debugf("Challenging security key factor %s, CredentialId: %s", f.Id, credentialId)
+
+	return mfa.NewFidoClientWithAuthenticator(authenticator,
+		challenge.Embedded.Factor.Embedded.Challenge.Challenge,
+		o.Domain,
+		credentialId,
+		stateToken)
+}
+
 func GetFactorId(f *OktaUserAuthnFactor) (id string, err error) {
 	switch f.FactorType {
 	case "web":
@@ -603,7 +888,9 @@ func (o *OktaClient) Get(method string, path string, data []byte, recv interface
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
+	if res.StatusCode == http.StatusForbidden && format == "saml" {
+		err = &AppNotAssignedError{Username: o.Username}
+	} else if res.StatusCode != http.StatusOK {
 		err = fmt.Errorf("%s %v: %s", method, url, res.Status)
 	} else if recv != nil {
 		switch format {
@@ -616,7 +903,8 @@ func (o *OktaClient) Get(method string, path string, data []byte, recv interface
 				return
 			}
 			if err := ParseSAML(rawData, recv.(*SAMLAssertion)); err != nil {
-				return fmt.Errorf("Okta user %s does not have the AWS app added to their account.  Please contact your Okta admin to make sure things are configured properly.", o.Username)
+				log.Debugf("No SAMLResponse from %s: %s", res.Request.URL, err)
+				return o.samlPageError(res.Request.URL, rawData)
 			}
 		}
 	}
@@ -624,6 +912,118 @@ func (o *OktaClient) Get(method string, path string, data []byte, recv interface
 	return
 }
 
+// AppNotAssignedError is returned when Okta answers the AWS app's SAML URL
+// with neither a SAMLResponse nor a sign-on policy challenge
+type AppNotAssignedError struct {
+	Username string
+}
+
+func (e *AppNotAssignedError) Error() string {
+	return fmt.Sprintf("Okta user %s does not have the AWS app added to their account.  Please contact your Okta admin to make sure things are configured properly.", e.Username)
+}
+
+// StepUpRequiredError is returned when the AWS app's sign-on policy requires
+// the user to re-authenticate or complete MFA before Okta releases the
+// SAMLResponse
+type StepUpRequiredError struct {
+	StateToken string
+}
+
+func (e *StepUpRequiredError) Error() string {
+	return "the AWS app's sign-on policy requires additional verification"
+}
+
+// ErrOktaSessionRequired is returned when Okta sends us to its login page
+// instead of the AWS app
+var ErrOktaSessionRequired = errors.New("the Okta session is missing or has expired")
+
+var stateTokenRegex = regexp.MustCompile(`var stateToken = '([^']+)'`)
+var jsHexEscapeRegex = regexp.MustCompile(`\\x([0-9a-fA-F]{2})`)
+
+// samlPageError explains why the page Okta answered the AWS app's SAML URL
+// with, which ended up at pageURL, has no SAMLResponse
+func (o *OktaClient) samlPageError(pageURL *url.URL, body []byte) error {
+	if strings.HasPrefix(pageURL.Path, "/login/step-up/") {
+		stateToken := pageURL.Query().Get("stateToken")
+		if stateToken == "" {
+			stateToken = GetStateToken(body)
+		}
+		if stateToken != "" {
+			return &StepUpRequiredError{StateToken: stateToken}
+		}
+	}
+	if strings.HasPrefix(pageURL.Path, "/login/") {
+		return ErrOktaSessionRequired
+	}
+	return &AppNotAssignedError{Username: o.Username}
+}
+
+// GetStateToken extracts the stateToken embedded in the script of an Okta
+// sign-in page, or returns "" if there is none
+func GetStateToken(body []byte) string {
+	matches := stateTokenRegex.FindSubmatch(body)
+	if matches == nil {
+		return ""
+	}
+	// the token is a javascript string literal, where '-' is escaped as \x2D
+	return jsHexEscapeRegex.ReplaceAllStringFunc(string(matches[1]), func(escape string) string {
+		b, err := strconv.ParseUint(escape[2:], 16, 8)
+		if err != nil {
+			return escape
+		}
+		return string([]byte{byte(b)})
+	})
+}
+
+// stepUp satisfies the AWS app's sign-on policy for the step-up transaction
+// in stepUpErr, then fetches the SAML assertion again
+func (o *OktaClient) stepUp(stepUpErr *StepUpRequiredError, assertion *SAMLAssertion) error {
+	log.Info("The AWS app's sign-on policy requires additional verification")
+
+	payload, err := json.Marshal(OktaStateToken{
+		StateToken: stepUpErr.StateToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	var oktaUserAuthn OktaUserAuthn
+	if err = o.Get("POST", "api/v1/authn", payload, &oktaUserAuthn, "json"); err != nil {
+		return fmt.Errorf("Failed to start step-up authentication with okta: %s", err)
+	}
+	o.UserAuth = &oktaUserAuthn
+	log.Debugf("Step-up status: %s", o.UserAuth.Status)
+
+	if o.UserAuth.Status == "UNAUTHENTICATED" {
+		payload, err = json.Marshal(OktaUser{
+			Username:   o.Username,
+			Password:   o.Password,
+			StateToken: stepUpErr.StateToken,
+		})
+		if err != nil {
+			return err
+		}
+		if err = o.Get("POST", "api/v1/authn", payload, o.UserAuth, "json"); err != nil {
+			return fmt.Errorf("Failed to re-authenticate with okta: %s", err)
+		}
+	}
+
+	if o.UserAuth.Status == "MFA_REQUIRED" {
+		log.Info("Requesting MFA. Please complete two-factor authentication with your second device")
+		if err = o.challengeMFA(); err != nil {
+			return err
+		}
+	}
+
+	if o.UserAuth.Status != "SUCCESS" {
+		return fmt.Errorf("step-up authentication failed for %s: %s", o.Username, o.UserAuth.Status)
+	}
+
+	// Okta redirects back to the AWS app once the transaction has succeeded
+	return o.Get("GET", "login/step-up/redirect?stateToken="+url.QueryEscape(stepUpErr.StateToken),
+		nil, assertion, "saml")
+}
+
 type OktaProvider struct {
 	Keyring         keyring.Keyring
 	ProfileARN      string
@@ -635,22 +1035,93 @@ type OktaProvider struct {
 	OktaAccountName      string
 	MFAConfig            MFAConfig
 	AwsRegion            string
+	SAMLValidation       *saml.ValidationOptions
+	SAMLCache            *SAMLAssertionCache
+	AccountAliases       AccountAliases
+	STSRegionalEndpoint  endpoints.STSRegionalEndpoint
+	SessionPolicy        SessionPolicy
+	// SessionDurationDefaulted is passed on to OktaClient
+	SessionDurationDefaulted bool
+	// SessionAttributes are set by Retrieve
+	SessionAttributes saml.SessionAttributes
 }
 
 func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
+	oktaClient, err := p.newOktaClient()
+	if err != nil {
+		return sts.Credentials{}, "", err
+	}
+
+	creds, newCookies, err := oktaClient.AuthenticateProfile3(p.ProfileARN, p.SessionDuration, p.AwsRegion)
+	if err != nil {
+		return sts.Credentials{}, "", err
+	}
+	p.SessionAttributes = oktaClient.SessionAttributes
+
+	p.saveCookies(newCookies)
+
+	return creds, oktaClient.Username, err
+}
+
+// RetrieveWithSAMLAssertion assumes the role with assertion, which was
+// obtained outside of aws-okta, without talking to Okta
+func (p *OktaProvider) RetrieveWithSAMLAssertion(assertion *SAMLAssertion) (sts.Credentials, error) {
+	if p.SAMLValidation != nil {
+		if err := ValidateSAML(assertion, *p.SAMLValidation); err != nil {
+			return sts.Credentials{}, err
+		}
+	}
+
+	oktaClient := OktaClient{
+		OktaAwsSAMLUrl:           p.OktaAwsSAMLUrl,
+		AccountAliases:           p.AccountAliases,
+		STSRegionalEndpoint:      p.STSRegionalEndpoint,
+		SessionPolicy:            p.SessionPolicy,
+		SessionDurationDefaulted: p.SessionDurationDefaulted,
+	}
+	creds, err := oktaClient.assumeRoleWithSAML(assertion, p.ProfileARN, p.SessionDuration, p.AwsRegion)
+	if err != nil {
+		return sts.Credentials{}, err
+	}
+	p.SessionAttributes = oktaClient.SessionAttributes
+
+	return creds, nil
+}
+
+// GetSAMLAssertion gets a new SAML assertion from Okta, without assuming any
+// role with it
+func (p *OktaProvider) GetSAMLAssertion() (*SAMLAssertion, error) {
+	oktaClient, err := p.newOktaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, newCookies, err := oktaClient.GetSAMLAssertion()
+	if err != nil {
+		return nil, err
+	}
+
+	p.saveCookies(newCookies)
+
+	return assertion, nil
+}
+
+// newOktaClient returns a client for the Okta credentials and cookies in the
+// keyring
+func (p *OktaProvider) newOktaClient() (*OktaClient, error) {
 	log.Debugf("Using okta provider (%s)", p.OktaAccountName)
 	item, err := p.Keyring.Get(p.OktaAccountName)
 	if err == keyring.ErrKeyNotFound {
-		return sts.Credentials{}, "", errors.New("Okta credentials are not in your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+		return nil, errors.New("Okta credentials are not in your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
 	}
 	if err != nil {
 		log.Debugf("Couldnt get okta creds from keyring: %s", err)
-		return sts.Credentials{}, "", err
+		return nil, err
 	}
 
 	var oktaCreds OktaCreds
 	if err = json.Unmarshal(item.Data, &oktaCreds); err != nil {
-		return sts.Credentials{}, "", errors.New("Failed to get okta credentials from your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
+		return nil, errors.New("Failed to get okta credentials from your keyring.  Please make sure you have added okta credentials with `aws-okta add`")
 	}
 
 	// Check for stored session and device token cookies
@@ -666,14 +1137,23 @@ func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
 
 	oktaClient, err := NewOktaClient2(oktaCreds, p.OktaAwsSAMLUrl, cookies, p.MFAConfig)
 	if err != nil {
-		return sts.Credentials{}, "", err
+		return nil, err
 	}
-
-	creds, newCookies, err := oktaClient.AuthenticateProfile3(p.ProfileARN, p.SessionDuration, p.AwsRegion)
+	oktaClient.Authenticator, err = mfa.NewAuthenticator(p.MFAConfig.FidoDevice, p.Keyring)
 	if err != nil {
-		return sts.Credentials{}, "", err
+		return nil, err
 	}
+	oktaClient.SAMLValidation = p.SAMLValidation
+	oktaClient.SAMLCache = p.SAMLCache
+	oktaClient.AccountAliases = p.AccountAliases
+	oktaClient.STSRegionalEndpoint = p.STSRegionalEndpoint
+	oktaClient.SessionPolicy = p.SessionPolicy
+	oktaClient.SessionDurationDefaulted = p.SessionDurationDefaulted
+
+	return oktaClient, nil
+}
 
+func (p *OktaProvider) saveCookies(newCookies OktaCookies) {
 	log.Debug("pOktaSessionCookieKey: ", p.OktaSessionCookieKey)
 
 	newCookieItem := keyring.Item{
@@ -693,8 +1173,6 @@ func (p *OktaProvider) Retrieve() (sts.Credentials, string, error) {
 	}
 
 	p.Keyring.Set(newCookieItem2)
-
-	return creds, oktaCreds.Username, err
 }
 
 func (p *OktaProvider) GetSAMLLoginURL() (*url.URL, error) {
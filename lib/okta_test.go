@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/99designs/keyring"
+	u2fhost "github.com/marshallbrekka/go-u2fhost"
+	"github.com/segmentio/aws-okta/lib/mfa"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingAuthenticator counts the times the user touches a security key
+type countingAuthenticator struct {
+	mfa.Authenticator
+	touches int
+}
+
+func (a *countingAuthenticator) Authenticate(req *u2fhost.AuthenticateRequest) (*u2fhost.AuthenticateResponse, error) {
+	response, err := a.Authenticator.Authenticate(req)
+	if err == nil {
+		a.touches++
+	}
+	return response, err
+}
+
+func TestChallengeAllSecurityKeys(t *testing.T) {
+	software := &mfa.SoftwareAuthenticator{Keyring: keyring.NewArrayKeyring([]keyring.Item{})}
+	keyHandle, _, err := software.Credential()
+	if err != nil {
+		t.Fatalf("creating credential: %s", err)
+	}
+	authenticator := &countingAuthenticator{Authenticator: software}
+	credentials := map[string]string{
+		"other-key":    "c29tZW9uZS1lbHNl",
+		"software-key": keyHandle,
+	}
+
+	// like Okta, every challenge gets a new nonce and state token
+	challenges := 0
+	nonces := map[string]string{}
+	var verified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		factorID := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/authn/factors/"), "/")[0]
+
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+
+		var resp OktaUserAuthn
+		if body["signatureData"] == "" {
+			challenges++
+			nonce := fmt.Sprintf("nonce-%d", challenges)
+			nonces["state-"+nonce] = nonce
+			resp.StateToken = "state-" + nonce
+			resp.Status = "MFA_CHALLENGE"
+			resp.Embedded.Factor.Id = factorID
+			resp.Embedded.Factor.Profile.CredentialId = credentials[factorID]
+			resp.Embedded.Factor.Embedded.Challenge.Challenge = nonce
+		} else {
+			clientJSON, err := base64.RawURLEncoding.DecodeString(body["clientData"])
+			if err != nil {
+				t.Errorf("decoding client data: %s", err)
+			}
+			assert.Contains(t, string(clientJSON), fmt.Sprintf(`"challenge":%q`, nonces[body["stateToken"]]))
+			verified = append(verified, factorID)
+			resp.StateToken = body["stateToken"]
+			resp.Status = "SUCCESS"
+			resp.SessionToken = "session-token"
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	o := &OktaClient{
+		BaseURL:       base,
+		Domain:        "example.okta.com",
+		MFAConfig:     MFAConfig{AllSecurityKeys: true},
+		Authenticator: authenticator,
+		UserAuth: &OktaUserAuthn{
+			StateToken: "state-token",
+			Status:     "MFA_REQUIRED",
+			Embedded: OktaUserAuthnEmbedded{
+				Factors: []OktaUserAuthnFactor{
+					{Id: "other-key", FactorType: "webauthn", Provider: "FIDO"},
+					{Id: "software-key", FactorType: "webauthn", Provider: "FIDO"},
+					{Id: "push", FactorType: "push", Provider: "OKTA"},
+				},
+			},
+		},
+	}
+
+	if err := o.challengeMFA(); err != nil {
+		t.Fatalf("challenging: %s", err)
+	}
+	assert.Equal(t, 2, challenges)
+	assert.Equal(t, 1, authenticator.touches)
+	assert.Equal(t, []string{"software-key"}, verified)
+	assert.Equal(t, "session-token", o.UserAuth.SessionToken)
+}
+
+const samlResponseXML = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="response-1">` +
+	`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="assertion-1"></saml2:Assertion>` +
+	`</samlp:Response>`
+
+func samlPage(xml string) string {
+	return fmt.Sprintf(`<html><body><form><input name="SAMLResponse" type="hidden" value="%s"/></form></body></html>`,
+		base64.StdEncoding.EncodeToString([]byte(xml)))
+}
+
+func TestGetStateToken(t *testing.T) {
+	body := []byte(`<script>var stateToken = '00abc\x2Ddef\x2D';\nvar other = 'x';</script>`)
+	assert.Equal(t, "00abc-def-", GetStateToken(body))
+	assert.Equal(t, "", GetStateToken([]byte("<html></html>")))
+}
+
+func TestSAMLPageError(t *testing.T) {
+	o := &OktaClient{Username: "someone"}
+
+	stepUpURL, _ := url.Parse("https://example.okta.com/login/step-up/redirect?stateToken=token")
+	assert.Equal(t, &StepUpRequiredError{StateToken: "token"}, o.samlPageError(stepUpURL, nil))
+
+	loginURL, _ := url.Parse("https://example.okta.com/login/login.htm?fromURI=%2Fhome")
+	assert.Equal(t, ErrOktaSessionRequired, o.samlPageError(loginURL, nil))
+
+	appURL, _ := url.Parse("https://example.okta.com/app/UserHome")
+	assert.Equal(t, &AppNotAssignedError{Username: "someone"}, o.samlPageError(appURL, nil))
+}
+
+func TestAuthenticateStepUp(t *testing.T) {
+	stepUpDone := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/home/amazon_aws/app/1", func(w http.ResponseWriter, r *http.Request) {
+		if !stepUpDone {
+			http.Redirect(w, r, "/login/step-up/redirect", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, samlPage(samlResponseXML))
+	})
+	mux.HandleFunc("/login/step-up/redirect", func(w http.ResponseWriter, r *http.Request) {
+		if !stepUpDone {
+			fmt.Fprint(w, `<script>var stateToken = '00step\x2Dup';</script>`)
+			return
+		}
+		assert.Equal(t, "00step-up", r.URL.Query().Get("stateToken"))
+		http.Redirect(w, r, "/home/amazon_aws/app/1", http.StatusFound)
+	})
+	mux.HandleFunc("/api/v1/authn", func(w http.ResponseWriter, r *http.Request) {
+		var user OktaUser
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+		assert.Equal(t, "00step-up", user.StateToken)
+
+		resp := OktaUserAuthn{StateToken: user.StateToken, Status: "UNAUTHENTICATED"}
+		if user.Password == "password" {
+			stepUpDone = true
+			resp.Status = "SUCCESS"
+			resp.SessionToken = "session-token"
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	base, _ := url.Parse(server.URL)
+	o := &OktaClient{
+		Username: "someone",
+		Password: "password",
+		BaseURL:  base,
+	}
+
+	var assertion SAMLAssertion
+	err := o.Get("GET", "home/amazon_aws/app/1", nil, &assertion, "saml")
+	stepUpErr, ok := err.(*StepUpRequiredError)
+	if !ok {
+		t.Fatalf("expected StepUpRequiredError; got %v", err)
+	}
+
+	if err := o.stepUp(stepUpErr, &assertion); err != nil {
+		t.Fatalf("stepping up: %s", err)
+	}
+	assert.Equal(t, "response-1", assertion.Resp.ID)
+	assert.Equal(t, "assertion-1", assertion.Resp.Assertion.ID)
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// Partition is the AWS partition a role is in, with the hosts that differ
+// between partitions
+type Partition struct {
+	ID string
+	// DefaultRegion is used for STS when the profile doesn't set a region.
+	// It is empty for the commercial partition, which has a global endpoint.
+	DefaultRegion string
+	SigninHost    string
+	ConsoleHost   string
+}
+
+var partitions = map[string]Partition{
+	endpoints.AwsPartitionID: {
+		ID:          endpoints.AwsPartitionID,
+		SigninHost:  "signin.aws.amazon.com",
+		ConsoleHost: "console.aws.amazon.com",
+	},
+	endpoints.AwsUsGovPartitionID: {
+		ID:            endpoints.AwsUsGovPartitionID,
+		DefaultRegion: endpoints.UsGovWest1RegionID,
+		SigninHost:    "signin.amazonaws-us-gov.com",
+		ConsoleHost:   "console.amazonaws-us-gov.com",
+	},
+	endpoints.AwsCnPartitionID: {
+		ID:            endpoints.AwsCnPartitionID,
+		DefaultRegion: endpoints.CnNorth1RegionID,
+		SigninHost:    "signin.amazonaws.cn",
+		ConsoleHost:   "console.amazonaws.cn",
+	},
+}
+
+var arnPartitionRegex = regexp.MustCompile(`^arn:([a-z-]+):`)
+
+// PartitionForARN returns the partition of arn, and checks that region, if
+// set, is in it. If arn can't be parsed, such as the empty role_arn of a
+// profile that lets the user pick a role, the partition is that of region,
+// or else the commercial partition.
+func PartitionForARN(arn, region string) (Partition, error) {
+	id := endpoints.AwsPartitionID
+	if matches := arnPartitionRegex.FindStringSubmatch(arn); matches != nil {
+		id = matches[1]
+	} else if rp, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		id = rp.ID()
+	}
+	p, ok := partitions[id]
+	if !ok {
+		return Partition{}, fmt.Errorf("unsupported AWS partition %s", id)
+	}
+	return p, p.CheckRegion(region)
+}
+
+// CheckRegion returns an error if region is known to be in another partition
+func (p Partition) CheckRegion(region string) error {
+	if region == "" {
+		return nil
+	}
+	rp, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if ok && rp.ID() != p.ID {
+		return fmt.Errorf("region %s is in the %s partition, not %s", region, rp.ID(), p.ID)
+	}
+	return nil
+}
+
+// FederationURL returns the endpoint for federated console sign in
+func (p Partition) FederationURL() string {
+	return "https://" + p.SigninHost + "/federation"
+}
+
+// ConsoleURL returns the console home page for region, which may be empty
+func (p Partition) ConsoleURL(region string) string {
+	switch {
+	case region == "":
+		return "https://" + p.ConsoleHost + "/"
+	case p.ID == endpoints.AwsPartitionID:
+		return fmt.Sprintf("https://%s.%s/console/home?region=%s", region, p.ConsoleHost, region)
+	}
+	return fmt.Sprintf("https://%s/console/home?region=%s", p.ConsoleHost, region)
+}
+
+// stsConfig returns the config for calling STS about roleARN from region,
+// which may be empty. Regional endpoints are used whenever there is a region,
+// unless stsEndpoint is endpoints.LegacySTSEndpoint.
+func stsConfig(roleARN, region string, stsEndpoint endpoints.STSRegionalEndpoint) (*aws.Config, error) {
+	p, err := PartitionForARN(roleARN, region)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = p.DefaultRegion
+	}
+
+	conf := &aws.Config{}
+	if region != "" {
+		conf.WithRegion(region)
+		if stsEndpoint == endpoints.UnsetSTSEndpoint {
+			stsEndpoint = endpoints.RegionalSTSEndpoint
+		}
+		conf.WithSTSRegionalEndpoint(stsEndpoint)
+	}
+	return conf, nil
+}
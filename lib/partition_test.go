@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionForARN(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		arn       string
+		region    string
+		partition string
+		err       bool
+	}{
+		{"commercial", "arn:aws:iam::123456789012:role/admin", "us-west-2", "aws", false},
+		{"govcloud", "arn:aws-us-gov:iam::123456789012:role/admin", "us-gov-east-1", "aws-us-gov", false},
+		{"china", "arn:aws-cn:iam::123456789012:role/admin", "", "aws-cn", false},
+		{"no role", "", "", "aws", false},
+		{"no role with region", "", "cn-northwest-1", "aws-cn", false},
+		{"govcloud role in commercial region", "arn:aws-us-gov:iam::123456789012:role/admin", "us-east-1", "aws-us-gov", true},
+		{"commercial role in china region", "arn:aws:iam::123456789012:role/admin", "cn-north-1", "aws", true},
+		{"unsupported partition", "arn:aws-iso:iam::123456789012:role/admin", "", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := PartitionForARN(tc.arn, tc.region)
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.partition, p.ID)
+		})
+	}
+}
+
+func TestPartitionURLs(t *testing.T) {
+	commercial, _ := PartitionForARN("arn:aws:iam::123456789012:role/admin", "")
+	assert.Equal(t, "https://signin.aws.amazon.com/federation", commercial.FederationURL())
+	assert.Equal(t, "https://console.aws.amazon.com/", commercial.ConsoleURL(""))
+	assert.Equal(t, "https://us-west-2.console.aws.amazon.com/console/home?region=us-west-2", commercial.ConsoleURL("us-west-2"))
+
+	govcloud, _ := PartitionForARN("arn:aws-us-gov:iam::123456789012:role/admin", "")
+	assert.Equal(t, "https://signin.amazonaws-us-gov.com/federation", govcloud.FederationURL())
+	assert.Equal(t, "https://console.amazonaws-us-gov.com/console/home?region=us-gov-west-1", govcloud.ConsoleURL("us-gov-west-1"))
+
+	china, _ := PartitionForARN("arn:aws-cn:iam::123456789012:role/admin", "")
+	assert.Equal(t, "https://signin.amazonaws.cn/federation", china.FederationURL())
+	assert.Equal(t, "https://console.amazonaws.cn/console/home?region=cn-north-1", china.ConsoleURL("cn-north-1"))
+}
+
+func TestSTSConfig(t *testing.T) {
+	conf, err := stsConfig("arn:aws:iam::123456789012:role/admin", "", endpoints.UnsetSTSEndpoint)
+	assert.NoError(t, err)
+	assert.Nil(t, conf.Region)
+
+	conf, err = stsConfig("arn:aws-us-gov:iam::123456789012:role/admin", "", endpoints.UnsetSTSEndpoint)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "us-gov-west-1", *conf.Region)
+	}
+
+	_, err = stsConfig("arn:aws-cn:iam::123456789012:role/admin", "eu-west-1", endpoints.UnsetSTSEndpoint)
+	assert.Error(t, err)
+
+	conf, err = stsConfig("arn:aws:iam::123456789012:role/admin", "eu-west-1", endpoints.UnsetSTSEndpoint)
+	if assert.NoError(t, err) {
+		assert.Equal(t, endpoints.RegionalSTSEndpoint, conf.STSRegionalEndpoint)
+	}
+
+	conf, err = stsConfig("arn:aws:iam::123456789012:role/admin", "eu-west-1", endpoints.LegacySTSEndpoint)
+	if assert.NoError(t, err) {
+		assert.Equal(t, endpoints.LegacySTSEndpoint, conf.STSRegionalEndpoint)
+	}
+}
@@ -2,18 +2,24 @@ package lib
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"errors"
 
+	"github.com/mitchellh/go-homedir"
+	"github.com/segmentio/aws-okta/lib/saml"
 	"github.com/segmentio/aws-okta/sessioncache"
-	"github.com/aws/aws-sdk-go/aws/endpoints"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/99designs/keyring"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	aws_session "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 
@@ -31,6 +37,26 @@ const (
 	DefaultAssumeRoleDuration = time.Minute * 15
 )
 
+// the stores sessions can be cached in; see ProviderOptions.SessionCacheStore
+const (
+	SessionCacheItemPerSession = "item-per-session"
+	SessionCacheSingleItem     = "single-item"
+	SessionCacheFile           = "file"
+)
+
+// AuthLockTimeout is how long to wait for another process authenticating for
+// the same profile, which may be waiting for MFA, before authenticating too
+const AuthLockTimeout = 2 * time.Minute
+
+// SessionCachePassphraseEnv is the environment variable of the passphrase the
+// file session cache is encrypted with; without it the file's key is kept in
+// the keyring
+const SessionCachePassphraseEnv = "AWS_OKTA_SESSION_CACHE_PASSPHRASE"
+
+// SessionCacheMaxSessionsEnv is the environment variable of the most sessions
+// the single-item session cache keeps
+const SessionCacheMaxSessionsEnv = "AWS_OKTA_SESSION_CACHE_MAX_SESSIONS"
+
 type ProviderOptions struct {
 	SessionDuration    time.Duration
 	AssumeRoleDuration time.Duration
@@ -38,9 +64,24 @@ type ProviderOptions struct {
 	Profiles           Profiles
 	MFAConfig          MFAConfig
 	AssumeRoleArn      string
+	// if true, SessionDuration is replaced by the SessionDuration attribute
+	// of the SAML assertion, if there is one
+	SessionDurationFromSAML bool
+	// if true, SessionDuration is a default rather than the user's choice,
+	// and is shortened to the SessionDuration attribute without a warning
+	SessionDurationDefaulted bool
+	// SAMLAssertion, if set, was obtained outside of aws-okta. It is used
+	// instead of a cached session or a new assertion from Okta.
+	SAMLAssertion *SAMLAssertion
+	// SessionPolicy scopes down the profile's credentials. Its fields
+	// override the profile's `session_policy` and `policy_arns`.
+	SessionPolicy SessionPolicy
 	// if true, use store_singlekritem SessionCache (new)
 	// if false, use store_kritempersession SessionCache (old)
 	SessionCacheSingleItem bool
+	// SessionCacheStore is one of the SessionCache* stores, and overrides
+	// SessionCacheSingleItem if set
+	SessionCacheStore string
 }
 
 func (o ProviderOptions) Validate() error {
@@ -65,6 +106,7 @@ func (o ProviderOptions) ApplyDefaults() ProviderOptions {
 	}
 	if o.SessionDuration == 0 {
 		o.SessionDuration = DefaultSessionDuration
+		o.SessionDurationDefaulted = true
 	}
 	return o
 }
@@ -72,6 +114,36 @@ func (o ProviderOptions) ApplyDefaults() ProviderOptions {
 type SessionCacheInterface interface {
 	Get(sessioncache.Key) (*sessioncache.Session, error)
 	Put(sessioncache.Key, *sessioncache.Session) error
+	List() ([]sessioncache.Entry, error)
+	Delete(sessioncache.Key) error
+}
+
+// NewSessionCache returns the session cache store, one of the SessionCache*
+// stores, kept in k
+func NewSessionCache(store string, k keyring.Keyring) (SessionCacheInterface, error) {
+	switch store {
+	case SessionCacheFile:
+		log.Debugf("Using FileStore")
+		return &sessioncache.FileStore{
+			Keyring:        k,
+			KeyringPrompts: KeyringPrompts(k),
+			Passphrase:     os.Getenv(SessionCachePassphraseEnv),
+		}, nil
+	case SessionCacheSingleItem:
+		log.Debugf("Using SingleKrItemStore")
+		var maxSessions int
+		if v := os.Getenv(SessionCacheMaxSessionsEnv); v != "" {
+			var err error
+			if maxSessions, err = strconv.Atoi(v); err != nil || maxSessions < 0 {
+				return nil, fmt.Errorf("invalid %s %q", SessionCacheMaxSessionsEnv, v)
+			}
+		}
+		return &sessioncache.SingleKrItemStore{Keyring: k, MaxSessions: maxSessions}, nil
+	case SessionCacheItemPerSession, "":
+		log.Debugf("Using KrItemPerSessionStore")
+		return &sessioncache.KrItemPerSessionStore{Keyring: k}, nil
+	}
+	return nil, fmt.Errorf("unknown session cache %q", store)
 }
 
 type Provider struct {
@@ -81,8 +153,15 @@ type Provider struct {
 	expires                time.Time
 	keyring                keyring.Keyring
 	sessions               SessionCacheInterface
+	samlAssertions         *SAMLAssertionCache
+	accountAliases         AccountAliases
 	profiles               Profiles
 	defaultRoleSessionName string
+	sessionAttributes      saml.SessionAttributes
+	oktaUsername           string
+	// samlSessionPolicy scopes down the SAML session, when it is the
+	// profile's own session rather than the source of a role chain
+	samlSessionPolicy SessionPolicy
 }
 
 func NewProvider(k keyring.Keyring, profile string, opts ProviderOptions) (*Provider, error) {
@@ -90,20 +169,27 @@ func NewProvider(k keyring.Keyring, profile string, opts ProviderOptions) (*Prov
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
-	var sessions SessionCacheInterface
-
-	if opts.SessionCacheSingleItem {
-		log.Debugf("Using SingleKrItemStore")
-		sessions = &sessioncache.SingleKrItemStore{k}
-	} else {
-		log.Debugf("Using KrItemPerSessionStore")
-		sessions = &sessioncache.KrItemPerSessionStore{k}
+	store := opts.SessionCacheStore
+	if store == "" && opts.SessionCacheSingleItem {
+		store = SessionCacheSingleItem
+	}
+	sessions, err := NewSessionCache(store, k)
+	if err != nil {
+		return nil, err
+	}
+	// without a system keyring, the file's key would be kept in the file
+	// backend, which prompts for its passphrase
+	if store == SessionCacheFile && os.Getenv(SessionCachePassphraseEnv) == "" && KeyringPrompts(k) {
+		return nil, fmt.Errorf("the file session cache needs %s to be set when there is no system keyring",
+			SessionCachePassphraseEnv)
 	}
 
 	return &Provider{
 		ProviderOptions: opts,
 		keyring:         k,
 		sessions:        sessions,
+		samlAssertions:  &SAMLAssertionCache{Sessions: sessions},
+		accountAliases:  LoadAccountAliases(opts.Profiles),
 		profile:         profile,
 		profiles:        opts.Profiles,
 	}, nil
@@ -122,53 +208,92 @@ func (p *Provider) Retrieve() (credentials.Value, error) {
 	if !ok {
 		return credentials.Value{}, fmt.Errorf("missing profile named %s", p.profile)
 	}
-	key := sessioncache.KeyWithProfileARN{
+	chain, err := p.profiles.SourceChain(p.profile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	log.Debugf("Role chain: %s", strings.Join(chain, " -> "))
+	if err := p.checkPartition(chain); err != nil {
+		return credentials.Value{}, err
+	}
+
+	// the session policy scopes down the credentials of the profile, so it
+	// applies to the last role of the chain, which may be the SAML session's
+	policy, err := p.sessionPolicy()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	lastHop := ""
+	for _, hop := range chain[1:] {
+		if _, ok := p.profiles[hop]["role_arn"]; ok {
+			lastHop = hop
+		}
+	}
+	if lastHop == "" {
+		p.samlSessionPolicy = policy
+	}
+
+	// a missing SAML URL fails when authenticating, unless we are given an
+	// assertion
+	samlURL, _ := p.getSamlURL()
+	roleARN := p.AssumeRoleArn
+	if roleARN == "" {
+		roleARN = p.profiles[source]["role_arn"]
+	}
+	key := sessioncache.OktaSessionKey{
+		SourceProfile: source,
+		SAMLURL:       samlURL,
+		OktaAccount:   p.getOktaAccountName(),
+		RoleARN:       roleARN,
+		Duration:      p.SessionDuration,
+		Policy:        p.samlSessionPolicy.String(),
+	}
+	// sessions cached by older versions
+	legacyKey := sessioncache.KeyWithProfileARN{
 		ProfileName: source,
 		ProfileConf: profileConf,
 		Duration:    p.SessionDuration,
 		ProfileARN:  p.AssumeRoleArn,
+		Policy:      p.samlSessionPolicy.String(),
 	}
 
-	var creds sts.Credentials
-	if cachedSession, err := p.sessions.Get(key); err != nil {
-		creds, err = p.getSamlSessionCreds()
-		if err != nil {
-			return credentials.Value{}, xerrors.Errorf("getting creds via SAML: %w", err)
-		}
-		newSession := sessioncache.Session{
-			Name:        p.roleSessionName(),
-			Credentials: creds,
-		}
-		if err = p.sessions.Put(key, &newSession); err != nil {
-			return credentials.Value{}, xerrors.Errorf("putting to sessioncache", err)
-		}
-
-		// TODO(nick): not really clear why this is done
-		p.defaultRoleSessionName = newSession.Name
-	} else {
-		creds = cachedSession.Credentials
-		p.defaultRoleSessionName = cachedSession.Name
+	creds, err := p.getSessionCreds(source, key, legacyKey)
+	if err != nil {
+		return credentials.Value{}, err
 	}
 
 	log.Debugf("Using session %s, expires in %s",
 		(*(creds.AccessKeyId))[len(*(creds.AccessKeyId))-4:],
 		creds.Expiration.Sub(time.Now()).String())
 
-	// If sourceProfile returns the same source then we do not need to assume a
-	// second role. Not assuming a second role allows us to assume IDP enabled
-	// roles directly.
-	if p.profile != source {
-		if role, ok := p.profiles[p.profile]["role_arn"]; ok {
-			var err error
-			creds, err = p.assumeRoleFromSession(creds, role)
-			if err != nil {
-				return credentials.Value{}, err
-			}
-
-			log.Debugf("using role %s expires in %s",
-				(*(creds.AccessKeyId))[len(*(creds.AccessKeyId))-4:],
-				creds.Expiration.Sub(time.Now()).String())
+	// The source profile's role was assumed with SAML; assume the roles of
+	// the rest of the chain in turn, each with the credentials of the one
+	// before. If the profile is its own source there are none, which allows
+	// us to assume IDP enabled roles directly.
+	for _, hop := range chain[1:] {
+		role, ok := p.profiles[hop]["role_arn"]
+		if !ok {
+			continue
+		}
+		assumed, err := p.assumedRoleFor(hop, role)
+		if err != nil {
+			return credentials.Value{}, xerrors.Errorf("profile %s: %w", hop, err)
+		}
+		if hop == lastHop {
+			assumed.Policy = policy
 		}
+		creds, err = p.getAssumedRoleCreds(creds, assumed, window)
+		if err != nil {
+			return credentials.Value{}, xerrors.Errorf("assuming role of profile %s: %w", hop, err)
+		}
+
+		log.Debugf("using role %s expires in %s",
+			(*(creds.AccessKeyId))[len(*(creds.AccessKeyId))-4:],
+			creds.Expiration.Sub(time.Now()).String())
+	}
+
+	if p.accountAliasesFromIAM() {
+		learnAccountAlias(creds, profileConf["role_arn"], p.profiles[source]["region"], p.stsRegionalEndpoint(), p.accountAliases)
 	}
 
 	p.SetExpiration(*(creds.Expiration), window)
@@ -184,6 +309,112 @@ func (p *Provider) Retrieve() (credentials.Value, error) {
 	return value, nil
 }
 
+// getSessionCreds returns the cached session at key, or at legacyKey, or else
+// authenticates with Okta and caches the new session. Only one process
+// authenticates for the source profile at once: the others wait for its
+// session.
+func (p *Provider) getSessionCreds(source string, key, legacyKey sessioncache.Key) (sts.Credentials, error) {
+	cachedSession, err := p.getCachedSession(key, legacyKey)
+	if err != nil && p.SAMLAssertion == nil {
+		lock, lockErr := sessioncache.LockFile(authLockPath(source), 0)
+		if xerrors.Is(lockErr, sessioncache.ErrLockTimeout) {
+			log.Infof("Waiting for another aws-okta to authenticate for profile %s", source)
+			lock, lockErr = sessioncache.LockFile(authLockPath(source), AuthLockTimeout)
+		}
+		if lockErr != nil {
+			log.Debugf("Authenticating without waiting for other processes: %s", lockErr)
+		} else {
+			defer lock.Unlock()
+			// another process may have authenticated while we waited
+			cachedSession, err = p.getCachedSession(key, legacyKey)
+		}
+	}
+
+	// a given SAML assertion replaces the cached session
+	if err == nil && p.SAMLAssertion == nil {
+		p.defaultRoleSessionName = cachedSession.Name
+		p.oktaUsername = cachedSession.Username
+		if cachedSession.Attributes != nil {
+			p.sessionAttributes = *cachedSession.Attributes
+		}
+		return cachedSession.Credentials, nil
+	}
+
+	creds, err := p.getSamlSessionCreds()
+	if err != nil {
+		return sts.Credentials{}, xerrors.Errorf("getting creds via SAML: %w", err)
+	}
+	newSession := sessioncache.Session{
+		Name:          p.roleSessionName(),
+		Attributes:    &p.sessionAttributes,
+		Username:      p.oktaUsername,
+		Profile:       p.profile,
+		SourceProfile: source,
+		Credentials:   creds,
+	}
+	if err = p.putSession(key, &newSession); err != nil {
+		return sts.Credentials{}, err
+	}
+
+	// TODO(nick): not really clear why this is done
+	p.defaultRoleSessionName = newSession.Name
+	return creds, nil
+}
+
+// getCachedSession returns the session at key or, failing that, the session
+// at legacyKey, which is then copied to key. The legacy session is left, for
+// older versions; like other sessions, it's pruned once expired.
+func (p *Provider) getCachedSession(key, legacyKey sessioncache.Key) (*sessioncache.Session, error) {
+	session, err := p.sessions.Get(key)
+	if err == nil {
+		return session, nil
+	}
+	legacySession, legacyErr := p.sessions.Get(legacyKey)
+	if legacyErr != nil {
+		return nil, err
+	}
+
+	log.Debugf("Migrating cached session %s to %s", legacyKey.Key(), key.Key())
+	if legacySession.Profile == "" {
+		legacySession.Profile = p.profile
+	}
+	legacySession.SourceProfile = sourceProfile(p.profile, p.profiles)
+	if putErr := p.sessions.Put(key, legacySession); putErr != nil {
+		log.Debugf("Failed to migrate cached session: %s", putErr)
+	}
+	return legacySession, nil
+}
+
+// putSession caches session at key. A session cache that can't be written
+// safely, because it is locked by another process, was written by a newer
+// aws-okta or can't be decrypted, is left alone: the session is still used,
+// but not cached.
+func (p *Provider) putSession(key sessioncache.Key, session *sessioncache.Session) error {
+	err := p.sessions.Put(key, session)
+	if xerrors.Is(err, sessioncache.ErrLockTimeout) || xerrors.Is(err, sessioncache.ErrDbTooNew) || xerrors.Is(err, sessioncache.ErrWriteConflict) {
+		log.Warnf("Not caching session: %s", err)
+		return nil
+	} else if xerrors.Is(err, sessioncache.ErrFileStoreKeyMismatch) {
+		log.Warnf("Not caching session: %s; run `aws-okta cache clear` to start it over", err)
+		return nil
+	} else if err != nil {
+		return xerrors.Errorf("putting to sessioncache: %w", err)
+	}
+	return nil
+}
+
+// authLockPath returns the lock file of authenticating for the source
+// profile
+func authLockPath(source string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, source)
+	return "~/.aws-okta/auth-" + name + ".lock"
+}
+
 func (p *Provider) GetExpiration() time.Time {
 	return p.expires
 }
@@ -215,16 +446,68 @@ func (p *Provider) getOktaAccountName() string {
 	return "okta-creds-" + oktaAccountName
 }
 
-func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
+// getSAMLValidation returns how to validate SAML assertions, or nil if they
+// shouldn't be. Validation is enabled by `saml_validate`, or by setting the
+// IdP metadata to check signatures against with `saml_idp_metadata`.
+func (p *Provider) getSAMLValidation() (*saml.ValidationOptions, error) {
+	enabled := false
+	if validate, _, err := p.profiles.GetValue(p.profile, "saml_validate"); err == nil {
+		enabled, err = strconv.ParseBool(validate)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing saml_validate: %w", err)
+		}
+	}
+
+	var opts saml.ValidationOptions
+	if metadataPath, profile, err := p.profiles.GetValue(p.profile, "saml_idp_metadata"); err == nil {
+		log.Debugf("Using saml_idp_metadata from profile %s: %s", profile, metadataPath)
+		metadataPath, err = homedir.Expand(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		metadata, err := ioutil.ReadFile(metadataPath)
+		if err != nil {
+			return nil, xerrors.Errorf("reading saml_idp_metadata: %w", err)
+		}
+		opts.Certificates, err = saml.ParseCertificates(metadata)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing saml_idp_metadata %s: %w", metadataPath, err)
+		}
+		enabled = true
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	if skew, _, err := p.profiles.GetValue(p.profile, "saml_clock_skew"); err == nil {
+		opts.ClockSkew, err = time.ParseDuration(skew)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing saml_clock_skew: %w", err)
+		}
+	}
+	if len(opts.Certificates) == 0 {
+		log.Debug("saml_idp_metadata is not set; SAML signatures will not be checked")
+	}
+	return &opts, nil
+}
+
+// oktaProvider returns the OktaProvider to get the profile's SAML assertion
+// and session from
+func (p *Provider) oktaProvider() (*OktaProvider, error) {
 	var profileARN string
 	var ok bool
 	source := sourceProfile(p.profile, p.profiles)
 	oktaAwsSAMLUrl, err := p.getSamlURL()
-	if err != nil {
-		return sts.Credentials{}, err
+	// the SAML URL isn't needed for an assertion that was given to us
+	if err != nil && p.SAMLAssertion == nil {
+		return nil, err
 	}
 	oktaSessionCookieKey := p.getOktaSessionCookieKey()
 	oktaAccountName := p.getOktaAccountName()
+	samlValidation, err := p.getSAMLValidation()
+	if err != nil {
+		return nil, err
+	}
 
 	// if the assumable role is passed it have it override what is in the profile
 	if p.AssumeRoleArn != "" {
@@ -245,7 +528,7 @@ func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
 		}
 	}
 
-	provider := OktaProvider{
+	provider := &OktaProvider{
 		MFAConfig:            p.ProviderOptions.MFAConfig,
 		Keyring:              p.keyring,
 		ProfileARN:           profileARN,
@@ -253,17 +536,98 @@ func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
 		OktaAwsSAMLUrl:       oktaAwsSAMLUrl,
 		OktaSessionCookieKey: oktaSessionCookieKey,
 		OktaAccountName:      oktaAccountName,
+		SAMLValidation:       samlValidation,
+		SAMLCache:            p.samlAssertions,
+		AccountAliases:       p.accountAliases,
+		STSRegionalEndpoint:  p.stsRegionalEndpoint(),
+		SessionPolicy:        p.samlSessionPolicy,
+		// the duration's default is the SessionDuration attribute with
+		// SessionDurationFromSAML
+		SessionDurationDefaulted: p.SessionDurationDefaulted || p.SessionDurationFromSAML,
+	}
+	if p.SessionDurationFromSAML {
+		// use the SessionDuration attribute
+		provider.SessionDuration = 0
 	}
 
 	if region := p.profiles[source]["region"]; region != "" {
 		provider.AwsRegion = region
 	}
 
-	creds, oktaUsername, err := provider.Retrieve()
+	return provider, nil
+}
+
+// accountAliasesFromIAM returns whether the profile's `account_aliases_from_iam`
+// is set, to look up the alias of the accounts it is used with
+func (p *Provider) accountAliasesFromIAM() bool {
+	value, _, err := p.profiles.GetValue(p.profile, "account_aliases_from_iam")
+	if err != nil {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("Ignoring invalid account_aliases_from_iam %q", value)
+		return false
+	}
+	return enabled
+}
+
+// checkPartition returns an error if the role or region of a profile of
+// chain is in another AWS partition than the source profile's role
+func (p *Provider) checkPartition(chain []string) error {
+	source := chain[0]
+	partition, err := PartitionForARN(p.profiles[source]["role_arn"], p.profiles[source]["region"])
+	if err != nil {
+		return fmt.Errorf("profile %s: %s", source, err)
+	}
+
+	for _, hop := range chain[1:] {
+		conf := p.profiles[hop]
+		rolePartition, err := PartitionForARN(conf["role_arn"], conf["region"])
+		if err != nil {
+			return fmt.Errorf("profile %s: %s", hop, err)
+		}
+		if rolePartition.ID != partition.ID {
+			return fmt.Errorf("profile %s is in the %s partition, but its source profile %s is in %s",
+				hop, rolePartition.ID, source, partition.ID)
+		}
+	}
+	return nil
+}
+
+// GetSAMLAssertion gets a new SAML assertion for the profile from Okta,
+// authenticating if needed
+func (p *Provider) GetSAMLAssertion() (*SAMLAssertion, error) {
+	provider, err := p.oktaProvider()
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetSAMLAssertion()
+}
+
+func (p *Provider) getSamlSessionCreds() (sts.Credentials, error) {
+	provider, err := p.oktaProvider()
+	if err != nil {
+		return sts.Credentials{}, err
+	}
+
+	var creds sts.Credentials
+	var oktaUsername string
+	if p.SAMLAssertion != nil {
+		creds, err = provider.RetrieveWithSAMLAssertion(p.SAMLAssertion)
+		oktaUsername = strings.TrimSpace(p.SAMLAssertion.Resp.Assertion.Subject.NameID.Value)
+	} else {
+		creds, oktaUsername, err = provider.Retrieve()
+	}
 	if err != nil {
 		return sts.Credentials{}, err
 	}
+	p.sessionAttributes = provider.SessionAttributes
+	p.oktaUsername = oktaUsername
 	p.defaultRoleSessionName = oktaUsername
+	if provider.SessionAttributes.RoleSessionName != "" {
+		p.defaultRoleSessionName = provider.SessionAttributes.RoleSessionName
+	}
 
 	return creds, nil
 }
@@ -300,8 +664,116 @@ func (p *Provider) GetSAMLLoginURL() (*url.URL, error) {
 	return loginURL, nil
 }
 
-// assumeRoleFromSession takes a session created with an okta SAML login and uses that to assume a role
-func (p *Provider) assumeRoleFromSession(creds sts.Credentials, roleArn string) (sts.Credentials, error) {
+// assumedRole is how to assume the role of a profile of the source chain,
+// following the AWS CLI's settings for the profile
+type assumedRole struct {
+	ARN         string
+	SessionName string
+	Duration    time.Duration
+	ExternalID  string
+	MFASerial   string
+	Policy      SessionPolicy
+	Tags        roleTags
+}
+
+// assumedRoleFor returns how to assume roleArn, the role of profile
+func (p *Provider) assumedRoleFor(profile, roleArn string) (assumedRole, error) {
+	tags, err := p.roleTagsFor(profile)
+	if err != nil {
+		return assumedRole{}, err
+	}
+	conf := p.profiles[profile]
+	return assumedRole{
+		ARN:         roleArn,
+		SessionName: p.roleSessionNameFor(profile),
+		Duration:    p.assumeRoleDurationFor(profile),
+		ExternalID:  conf["external_id"],
+		MFASerial:   conf["mfa_serial"],
+		Tags:        tags,
+	}, nil
+}
+
+// roleTagsFor returns the session tags and source identity to assume the
+// role of profile with: its `role_tags`, `transitive_tag_keys` and
+// `source_identity`, which are looked up like other settings. If none are
+// set, the session is tagged with the Okta username (see DefaultRoleTags),
+// and its source identity is the SAML assertion's or else the username.
+func (p *Provider) roleTagsFor(profile string) (roleTags, error) {
+	data := RoleTagsData{
+		Username:   p.oktaUsername,
+		Attributes: p.sessionAttributes,
+	}
+
+	tagsConf, _, tagsErr := p.profiles.GetValue(profile, "role_tags")
+	transitiveConf, _, transitiveErr := p.profiles.GetValue(profile, "transitive_tag_keys")
+	sourceIdentityConf, _, sourceIdentityErr := p.profiles.GetValue(profile, "source_identity")
+
+	tags := roleTags{
+		Defaulted: tagsErr != nil && transitiveErr != nil && sourceIdentityErr != nil,
+	}
+	if tags.Defaulted {
+		tagsConf = DefaultRoleTags
+		sourceIdentityConf = "{{or .Attributes.SourceIdentity .Username}}"
+	}
+
+	var err error
+	if tags.Tags, err = parseRoleTags(tagsConf, data); err != nil {
+		return roleTags{}, xerrors.Errorf("parsing role_tags: %w", err)
+	}
+	for _, key := range strings.Split(transitiveConf, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			tags.TransitiveTagKeys = append(tags.TransitiveTagKeys, key)
+		}
+	}
+	if tags.SourceIdentity, err = expandRoleTagTemplate(sourceIdentityConf, data); err != nil {
+		return roleTags{}, xerrors.Errorf("parsing source_identity: %w", err)
+	}
+	return tags, nil
+}
+
+// getAssumedRoleCreds returns the cached credentials of role, unless they
+// expire within window, or else assumes role with creds and caches them
+func (p *Provider) getAssumedRoleCreds(creds sts.Credentials, role assumedRole, window time.Duration) (sts.Credentials, error) {
+	key := sessioncache.AssumedRoleKey{
+		RoleARN:         role.ARN,
+		RoleSessionName: role.SessionName,
+		Duration:        role.Duration,
+		ExternalID:      role.ExternalID,
+		MFASerial:       role.MFASerial,
+		Policy:          role.Policy.String(),
+		Tags:            role.Tags.String(),
+	}
+
+	// like the SAML session, the cached session is replaced when we are
+	// given a SAML assertion
+	if cachedSession, err := p.sessions.Get(key); err == nil && p.SAMLAssertion == nil {
+		if time.Until(*cachedSession.Expiration) > window {
+			log.Debugf("Using cached session for role %s", role.ARN)
+			return cachedSession.Credentials, nil
+		}
+		log.Debugf("Cached session for role %s expires in %s, renewing it",
+			role.ARN, time.Until(*cachedSession.Expiration))
+	}
+
+	assumed, err := p.assumeRoleFromSession(creds, role)
+	if err != nil {
+		return sts.Credentials{}, err
+	}
+	newSession := sessioncache.Session{
+		Name:          key.RoleSessionName,
+		Profile:       p.profile,
+		SourceProfile: sourceProfile(p.profile, p.profiles),
+		Credentials:   assumed,
+	}
+	if err := p.putSession(key, &newSession); err != nil {
+		return sts.Credentials{}, err
+	}
+	return assumed, nil
+}
+
+// assumeRoleFromSession takes a session created with an okta SAML login, or
+// by assuming a role from one, and uses that to assume a role
+func (p *Provider) assumeRoleFromSession(creds sts.Credentials, role assumedRole) (sts.Credentials, error) {
 	conf := &aws.Config{
 		Credentials: credentials.NewStaticCredentials(
 			*creds.AccessKeyId,
@@ -309,21 +781,51 @@ func (p *Provider) assumeRoleFromSession(creds sts.Credentials, roleArn string)
 			*creds.SessionToken,
 		),
 	}
-	if region := p.profiles[sourceProfile(p.profile, p.profiles)]["region"]; region != "" {
-		conf.WithRegion(region)
-		conf.WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint)
+	region := p.profiles[sourceProfile(p.profile, p.profiles)]["region"]
+	stsConf, err := stsConfig(role.ARN, region, p.stsRegionalEndpoint())
+	if err != nil {
+		return sts.Credentials{}, err
 	}
-	sess := aws_session.Must(aws_session.NewSession(conf))
+	sess := aws_session.Must(aws_session.NewSession(conf, stsConf))
 	client := sts.New(sess)
 
 	input := &sts.AssumeRoleInput{
-		RoleArn:         aws.String(roleArn),
-		RoleSessionName: aws.String(p.roleSessionName()),
-		DurationSeconds: aws.Int64(int64(p.AssumeRoleDuration.Seconds())),
+		RoleArn:         aws.String(role.ARN),
+		RoleSessionName: aws.String(role.SessionName),
+		DurationSeconds: aws.Int64(int64(role.Duration.Seconds())),
+	}
+	if role.ExternalID != "" {
+		input.ExternalId = aws.String(role.ExternalID)
+	}
+	if role.Policy.Policy != "" {
+		input.Policy = aws.String(role.Policy.Policy)
+	}
+	input.PolicyArns = role.Policy.policyDescriptors()
+	if role.MFASerial != "" {
+		code, err := p.mfaTokenCode(role.MFASerial, "")
+		if err != nil {
+			return sts.Credentials{}, err
+		}
+		input.SerialNumber = aws.String(role.MFASerial)
+		input.TokenCode = aws.String(code)
+	}
+
+	log.Debugf("Assuming role %s from session token", role.ARN)
+	req, resp := client.AssumeRoleRequest(input)
+	addRoleTags(req, role.Tags)
+	err = req.Send()
+	if err != nil && role.Tags.Defaulted && !role.Tags.IsZero() && isRoleTagsRejected(err) {
+		log.Debugf("Assuming role %s without the default session tags: %s", role.ARN, err)
+		if role.MFASerial != "" {
+			// STS doesn't take the same code twice
+			code, err := p.mfaTokenCode(role.MFASerial, *input.TokenCode)
+			if err != nil {
+				return sts.Credentials{}, err
+			}
+			input.TokenCode = aws.String(code)
+		}
+		resp, err = client.AssumeRole(input)
 	}
-
-	log.Debugf("Assuming role %s from session token", roleArn)
-	resp, err := client.AssumeRole(input)
 	if err != nil {
 		return sts.Credentials{}, err
 	}
@@ -331,11 +833,113 @@ func (p *Provider) assumeRoleFromSession(creds sts.Credentials, roleArn string)
 	return *resp.Credentials, nil
 }
 
-// roleSessionName returns the profile's `role_session_name` if set, or the
-// provider's defaultRoleSessionName if set. If neither is set, returns some
-// arbitrary unique string
+// mfaTokenCode returns the current code of the MFA device serial: computed
+// from its secret if that was added to the keyring with `aws-okta
+// add-mfa-secret`, or else entered by the user. If used, the code already
+// sent, is set, it waits for the next code.
+func (p *Provider) mfaTokenCode(serial, used string) (string, error) {
+	item, err := p.keyring.Get(MFASecretKeyringKey(serial))
+	if err == nil {
+		log.Debugf("Computing MFA code for %s", serial)
+		now := time.Now()
+		code, err := TOTPCode(string(item.Data), now)
+		if err != nil || code != used {
+			return code, err
+		}
+		next := time.Unix((now.Unix()/30+1)*30, 0)
+		log.Infof("Waiting %s for the next MFA code for %s", time.Until(next).Round(time.Second), serial)
+		time.Sleep(time.Until(next))
+		return TOTPCode(string(item.Data), next)
+	}
+	if err != keyring.ErrKeyNotFound {
+		log.Debugf("Couldn't get the MFA secret of %s: %s", serial, err)
+	}
+	if used != "" {
+		return Prompt("Enter the next MFA code for "+serial, false)
+	}
+	return Prompt("Enter MFA code for "+serial, false)
+}
+
+// sessionPolicy returns the policy to scope the profile's credentials down
+// with: the profile's own `session_policy` and `policy_arns`, unless they are
+// overridden by the options
+func (p *Provider) sessionPolicy() (SessionPolicy, error) {
+	policy := p.SessionPolicy
+	conf := p.profiles[p.profile]
+	if policy.Policy == "" && conf["session_policy"] != "" {
+		var err error
+		if policy.Policy, err = ReadSessionPolicy(conf["session_policy"]); err != nil {
+			return SessionPolicy{}, xerrors.Errorf("profile %s: %w", p.profile, err)
+		}
+	}
+	if len(policy.ARNs) == 0 {
+		policy.ARNs = ParsePolicyARNs(conf["policy_arns"])
+	}
+	if !policy.IsZero() {
+		log.Debugf("Scoping down credentials with session policy %s", policy)
+	}
+	return policy, nil
+}
+
+// stsRegionalEndpoint returns the profile's `sts_regional_endpoints`: whether
+// to call STS at its regional endpoints (the default) or its legacy global
+// one
+func (p *Provider) stsRegionalEndpoint() endpoints.STSRegionalEndpoint {
+	value, _, err := p.profiles.GetValue(p.profile, "sts_regional_endpoints")
+	if err != nil {
+		return endpoints.UnsetSTSEndpoint
+	}
+	stsEndpoint, err := endpoints.GetSTSRegionalEndpoint(value)
+	if err != nil {
+		log.Warnf("Ignoring invalid sts_regional_endpoints %q", value)
+		return endpoints.UnsetSTSEndpoint
+	}
+	return stsEndpoint
+}
+
+// SessionAttributes returns the attributes of the SAML assertion that the
+// session returned by Retrieve was created with
+func (p *Provider) SessionAttributes() saml.SessionAttributes {
+	return p.sessionAttributes
+}
+
+// roleSessionName returns the session name for the role of the provider's
+// profile; see roleSessionNameFor
 func (p *Provider) roleSessionName() string {
-	if name := p.profiles[p.profile]["role_session_name"]; name != "" {
+	return p.roleSessionNameFor(p.profile)
+}
+
+// assumeRoleDurationFor returns how long to assume the role of profile for:
+// AssumeRoleDuration for the provider's profile, which comes from the flags
+// or its duration_seconds or assume_role_ttl, or for other profiles of the
+// chain their own `duration_seconds` or `assume_role_ttl` if they set one
+func (p *Provider) assumeRoleDurationFor(profile string) time.Duration {
+	if profile == p.profile {
+		return p.AssumeRoleDuration
+	}
+	if seconds := p.profiles[profile]["duration_seconds"]; seconds != "" {
+		duration, err := strconv.Atoi(seconds)
+		if err == nil {
+			return time.Duration(duration) * time.Second
+		}
+		log.Warnf("Ignoring invalid duration_seconds %q of profile %s", seconds, profile)
+	}
+	if ttl := p.profiles[profile]["assume_role_ttl"]; ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err == nil {
+			return duration
+		}
+		log.Warnf("Ignoring invalid assume_role_ttl %q of profile %s", ttl, profile)
+	}
+	return p.AssumeRoleDuration
+}
+
+// roleSessionNameFor returns profile's `role_session_name` if set, or the
+// provider's defaultRoleSessionName if set: the SAML assertion's
+// RoleSessionName, or the Okta username. If neither is set, returns some
+// arbitrary unique string
+func (p *Provider) roleSessionNameFor(profile string) string {
+	if name := p.profiles[profile]["role_session_name"]; name != "" {
 		return name
 	}
 
@@ -357,11 +961,12 @@ func (p *Provider) GetRoleARNWithRegion(creds credentials.Value) (string, error)
 			creds.SessionToken,
 		),
 	}
-	if region := p.profiles[sourceProfile(p.profile, p.profiles)]["region"]; region != "" {
-		conf.WithRegion(region)
-		conf.WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint)
+	source := sourceProfile(p.profile, p.profiles)
+	stsConf, err := stsConfig(p.profiles[source]["role_arn"], p.profiles[source]["region"], p.stsRegionalEndpoint())
+	if err != nil {
+		return "", err
 	}
-	sess := aws_session.Must(aws_session.NewSession(conf))
+	sess := aws_session.Must(aws_session.NewSession(conf, stsConf))
 	client := sts.New(sess)
 
 	indentity, err := client.GetCallerIdentity(&sts.GetCallerIdentityInput{})
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/aws-okta/lib/saml"
+)
+
+// closestRolesCount is how many roles are suggested when none matches
+const closestRolesCount = 5
+
+// findRole returns the one role of roleList that pattern matches. pattern
+// may be a role ARN, a role name, `<account>:<role name>` where the account
+// is an ID or alias, or a glob of any of these (see path.Match).
+func findRole(roleList saml.AssumableRoles, pattern string, aliases AccountAliases) (saml.AssumableRole, error) {
+	var matches saml.AssumableRoles
+	for _, role := range roleList {
+		if roleMatches(role.Role, pattern, aliases) {
+			matches = append(matches, role)
+		}
+	}
+
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return saml.AssumableRole{}, fmt.Errorf("No role matches %s. The closest roles are:\n  %s",
+			pattern, strings.Join(closestRoles(roleList, pattern, closestRolesCount), "\n  "))
+	}
+	var arns []string
+	for _, role := range matches {
+		arns = append(arns, role.Role)
+	}
+	sort.Strings(arns)
+	return saml.AssumableRole{}, fmt.Errorf("%s matches several roles, please be more specific:\n  %s",
+		pattern, strings.Join(arns, "\n  "))
+}
+
+// roleMatches returns whether pattern, as accepted by findRole, matches
+// roleARN
+func roleMatches(roleARN, pattern string, aliases AccountAliases) bool {
+	if strings.HasPrefix(pattern, "arn:") {
+		return globMatch(pattern, roleARN)
+	}
+
+	accountID, roleName := accountIDAndRoleFromRoleARN(roleARN)
+	rolePattern := pattern
+	if i := strings.LastIndex(pattern, ":"); i >= 0 {
+		accountPattern := pattern[:i]
+		rolePattern = pattern[i+1:]
+		alias := aliases[accountID]
+		if !globMatch(accountPattern, accountID) && (alias == "" || !globMatch(accountPattern, alias)) {
+			return false
+		}
+	}
+	// roles with a path match by their name alone, too
+	return globMatch(rolePattern, roleName) || globMatch(rolePattern, path.Base(roleName))
+}
+
+func globMatch(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// patternNeedsAliases returns whether pattern names an account by alias, so
+// that aliases need to be looked up to resolve it
+func patternNeedsAliases(pattern string) bool {
+	if strings.HasPrefix(pattern, "arn:") {
+		return false
+	}
+	i := strings.LastIndex(pattern, ":")
+	if i < 0 {
+		return false
+	}
+	for _, r := range pattern[:i] {
+		if r < '0' || r > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// closestRoles returns the ARNs of the n roles of roleList that are the
+// closest to pattern, by edit distance to what pattern looks like
+func closestRoles(roleList saml.AssumableRoles, pattern string, n int) []string {
+	type candidate struct {
+		arn      string
+		distance int
+	}
+	var candidates []candidate
+	for _, role := range roleList {
+		accountID, roleName := accountIDAndRoleFromRoleARN(role.Role)
+		name := roleName
+		if strings.HasPrefix(pattern, "arn:") {
+			name = role.Role
+		} else if strings.Contains(pattern, ":") {
+			name = accountID + ":" + roleName
+		}
+		candidates = append(candidates, candidate{role.Role, editDistance(pattern, name)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].arn < candidates[j].arn
+	})
+
+	var arns []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		arns = append(arns, candidates[i].arn)
+	}
+	return arns
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	previous := make([]int, len(br)+1)
+	current := make([]int, len(br)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		current[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(br)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,66 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/segmentio/aws-okta/lib/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+var matchRoles = saml.AssumableRoles{
+	{Role: "arn:aws:iam::111111111111:role/admin", Principal: "arn:aws:iam::111111111111:saml-provider/okta"},
+	{Role: "arn:aws:iam::111111111111:role/read-only", Principal: "arn:aws:iam::111111111111:saml-provider/okta"},
+	{Role: "arn:aws:iam::222222222222:role/admin", Principal: "arn:aws:iam::222222222222:saml-provider/okta"},
+	{Role: "arn:aws:iam::222222222222:role/team/deploy", Principal: "arn:aws:iam::222222222222:saml-provider/okta"},
+}
+
+func TestGetRoleByPattern(t *testing.T) {
+	aliases := AccountAliases{"111111111111": "prod", "222222222222": "staging"}
+
+	for _, tc := range []struct {
+		pattern string
+		role    string
+	}{
+		{"arn:aws:iam::111111111111:role/admin", "arn:aws:iam::111111111111:role/admin"},
+		{"read-only", "arn:aws:iam::111111111111:role/read-only"},
+		{"deploy", "arn:aws:iam::222222222222:role/team/deploy"},
+		{"team/deploy", "arn:aws:iam::222222222222:role/team/deploy"},
+		{"222222222222:admin", "arn:aws:iam::222222222222:role/admin"},
+		{"prod:admin", "arn:aws:iam::111111111111:role/admin"},
+		{"stag*:admin", "arn:aws:iam::222222222222:role/admin"},
+		{"read-*", "arn:aws:iam::111111111111:role/read-only"},
+		{"arn:aws:iam::*:role/read-only", "arn:aws:iam::111111111111:role/read-only"},
+	} {
+		t.Run(tc.pattern, func(t *testing.T) {
+			role, err := GetRoleWithAliases(matchRoles, tc.pattern, aliases)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.role, role.Role)
+			}
+		})
+	}
+
+	_, err := GetRoleWithAliases(matchRoles, "admin", aliases)
+	assert.EqualError(t, err, "admin matches several roles, please be more specific:\n"+
+		"  arn:aws:iam::111111111111:role/admin\n"+
+		"  arn:aws:iam::222222222222:role/admin")
+
+	_, err = GetRoleWithAliases(matchRoles, "readonly", aliases)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "No role matches readonly. The closest roles are:\n"+
+			"  arn:aws:iam::111111111111:role/read-only\n")
+	}
+}
+
+func TestPatternNeedsAliases(t *testing.T) {
+	assert.True(t, patternNeedsAliases("prod:admin"))
+	assert.False(t, patternNeedsAliases("111111111111:admin"))
+	assert.False(t, patternNeedsAliases("admin"))
+	assert.False(t, patternNeedsAliases("arn:aws:iam::111111111111:role/admin"))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("admin", "admin"))
+	assert.Equal(t, 1, editDistance("readonly", "read-only"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+	assert.Equal(t, 5, editDistance("", "admin"))
+}
@@ -0,0 +1,338 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/segmentio/aws-okta/lib/saml"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// RecentRolesFile remembers the role last picked for each aws_saml_url, to
+// preselect it the next time
+const RecentRolesFile = "~/.aws-okta/recent-roles.json"
+
+// rolePickerHeight is how many roles the interactive picker shows at once
+const rolePickerHeight = 15
+
+// PickRole prompts the user for one of roleList, with a filterable list if
+// stdin and stderr are terminals, or else by number. The role with the ARN
+// recent, if any, is preselected.
+func PickRole(roleList saml.AssumableRoles, aliases AccountAliases, recent string) (saml.AssumableRole, error) {
+	// Sort the roles in alphabetical order
+	sort.Slice(roleList, func(i, j int) bool {
+		return roleList[i].Role < roleList[j].Role
+	})
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) && terminal.IsTerminal(int(os.Stderr.Fd())) {
+		return pickRoleInteractively(roleList, aliases, recent)
+	}
+	return pickRoleByNumber(roleList, aliases, recent)
+}
+
+func pickRoleByNumber(roleList saml.AssumableRoles, aliases AccountAliases, recent string) (saml.AssumableRole, error) {
+	var roleName, previousAccountID, currentAccountID string
+
+	recentIdx := -1
+	for i, arole := range roleList {
+		currentAccountID, roleName = accountIDAndRoleFromRoleARN(arole.Role)
+		if currentAccountID != previousAccountID {
+			fmt.Fprintf(os.Stderr, "\nAccount: %s\n", aliases.Name(currentAccountID))
+		}
+		previousAccountID = currentAccountID
+
+		if arole.Role == recent {
+			recentIdx = i
+			fmt.Fprintf(os.Stderr, "%4d - %s (last used)\n", i, roleName)
+		} else {
+			fmt.Fprintf(os.Stderr, "%4d - %s\n", i, roleName)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "")
+
+	prompt := "Select Role to Assume"
+	if recentIdx >= 0 {
+		prompt = fmt.Sprintf("%s [%d]", prompt, recentIdx)
+	}
+	i, err := Prompt(prompt, false)
+	if err != nil {
+		return saml.AssumableRole{}, err
+	}
+	if i == "" && recentIdx >= 0 {
+		return roleList[recentIdx], nil
+	}
+	if i == "" {
+		return saml.AssumableRole{}, errors.New("Invalid selection - Please use an option that is listed")
+	}
+	factorIdx, err := strconv.Atoi(i)
+	if err != nil {
+		return saml.AssumableRole{}, err
+	}
+	if factorIdx < 0 || factorIdx > (len(roleList)-1) {
+		return saml.AssumableRole{}, errors.New("Invalid selection - Please use an option that is listed")
+	}
+	return roleList[factorIdx], nil
+}
+
+func pickRoleInteractively(roleList saml.AssumableRoles, aliases AccountAliases, recent string) (saml.AssumableRole, error) {
+	picker := newRolePicker(roleList, aliases, recent)
+
+	state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return saml.AssumableRole{}, err
+	}
+	defer terminal.Restore(int(os.Stdin.Fd()), state)
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		picker.render(os.Stderr)
+		key, err := readKey(in)
+		if err != nil {
+			return saml.AssumableRole{}, err
+		}
+		done, err := picker.handleKey(key)
+		if err != nil || done {
+			picker.clear(os.Stderr)
+			if err != nil {
+				return saml.AssumableRole{}, err
+			}
+			role := picker.selectedRole()
+			fmt.Fprintf(os.Stderr, "Assuming %s\r\n", role.Role)
+			return role, nil
+		}
+	}
+}
+
+// keys that aren't runes
+const (
+	keyUp rune = -(iota + 1)
+	keyDown
+	keyCancel
+)
+
+// readKey reads a key press from a terminal in raw mode, turning the escape
+// sequences of the arrow keys into keyUp and keyDown
+func readKey(in *bufio.Reader) (rune, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch r {
+	case 3: // ctrl-c
+		return keyCancel, nil
+	case 16: // ctrl-p
+		return keyUp, nil
+	case 14: // ctrl-n
+		return keyDown, nil
+	case 27: // escape
+		if next, _, err := in.ReadRune(); err != nil || (next != '[' && next != 'O') {
+			return 0, err
+		}
+		code, _, err := in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		switch code {
+		case 'A':
+			return keyUp, nil
+		case 'B':
+			return keyDown, nil
+		}
+		return 0, nil
+	}
+	return r, nil
+}
+
+// rolePicker is the state of the interactive role picker: the roles matching
+// the filter typed so far, and the selected one
+type rolePicker struct {
+	roles    saml.AssumableRoles
+	labels   []string
+	filter   []rune
+	matches  []int
+	selected int
+	// lines is how many lines were last rendered, to be redrawn over
+	lines int
+}
+
+func newRolePicker(roleList saml.AssumableRoles, aliases AccountAliases, recent string) *rolePicker {
+	p := &rolePicker{roles: roleList}
+	for _, role := range roleList {
+		accountID, roleName := accountIDAndRoleFromRoleARN(role.Role)
+		p.labels = append(p.labels, aliases.Name(accountID)+"  "+roleName)
+	}
+	p.update()
+	for i, idx := range p.matches {
+		if roleList[idx].Role == recent {
+			p.selected = i
+		}
+	}
+	return p
+}
+
+// update filters the roles with the current filter
+func (p *rolePicker) update() {
+	p.matches = p.matches[:0]
+	for i, label := range p.labels {
+		if fuzzyMatch(string(p.filter), label) {
+			p.matches = append(p.matches, i)
+		}
+	}
+	p.selected = 0
+}
+
+// handleKey updates the picker for key, and returns true once a role is
+// picked
+func (p *rolePicker) handleKey(key rune) (bool, error) {
+	switch key {
+	case keyCancel:
+		return false, errors.New("No role selected")
+	case keyUp:
+		if p.selected > 0 {
+			p.selected--
+		}
+	case keyDown:
+		if p.selected < len(p.matches)-1 {
+			p.selected++
+		}
+	case '\r', '\n':
+		return len(p.matches) > 0, nil
+	case 127, 8: // backspace
+		if len(p.filter) > 0 {
+			p.filter = p.filter[:len(p.filter)-1]
+			p.update()
+		}
+	case 21: // ctrl-u
+		p.filter = nil
+		p.update()
+	default:
+		if unicode.IsPrint(key) {
+			p.filter = append(p.filter, key)
+			p.update()
+		}
+	}
+	return false, nil
+}
+
+func (p *rolePicker) selectedRole() saml.AssumableRole {
+	return p.roles[p.matches[p.selected]]
+}
+
+// render draws the picker over what it last drew. The terminal is in raw
+// mode, so lines end with \r\n.
+func (p *rolePicker) render(w io.Writer) {
+	p.clear(w)
+
+	fmt.Fprintf(w, "Select Role to Assume (type to filter, arrows to move, enter to pick)\r\n")
+	lines := 1
+
+	// scroll to keep the selected role in view
+	first := 0
+	if p.selected >= rolePickerHeight {
+		first = p.selected - rolePickerHeight + 1
+	}
+	for i := first; i < len(p.matches) && i < first+rolePickerHeight; i++ {
+		cursor := "  "
+		if i == p.selected {
+			cursor = "> "
+		}
+		fmt.Fprintf(w, "%s%s\r\n", cursor, p.labels[p.matches[i]])
+		lines++
+	}
+	if len(p.matches) == 0 {
+		fmt.Fprintf(w, "  no matching roles\r\n")
+		lines++
+	}
+	fmt.Fprintf(w, "%d/%d > %s", len(p.matches), len(p.roles), string(p.filter))
+	p.lines = lines
+}
+
+// clear erases what the picker last drew
+func (p *rolePicker) clear(w io.Writer) {
+	if p.lines > 0 {
+		fmt.Fprintf(w, "\r\x1b[%dA\x1b[J", p.lines)
+	} else {
+		fmt.Fprintf(w, "\r\x1b[J")
+	}
+	p.lines = 0
+}
+
+// fuzzyMatch returns whether the runes of pattern appear in s in order,
+// ignoring case
+func fuzzyMatch(pattern, s string) bool {
+	s = strings.ToLower(s)
+	for _, r := range strings.ToLower(pattern) {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
+
+// loadRecentRole returns the ARN of the role last picked for samlURL
+func loadRecentRole(samlURL string) string {
+	roles, err := readRecentRolesFile(RecentRolesFile)
+	if err != nil {
+		log.Debugf("Couldn't read recent roles: %s", err)
+	}
+	return roles[samlURL]
+}
+
+// saveRecentRole remembers that roleARN was picked for samlURL
+func saveRecentRole(samlURL, roleARN string) {
+	if err := updateRecentRolesFile(RecentRolesFile, samlURL, roleARN); err != nil {
+		log.Debugf("Couldn't save recent role: %s", err)
+	}
+}
+
+func readRecentRolesFile(path string) (map[string]string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	roles := map[string]string{}
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func updateRecentRolesFile(path, samlURL, roleARN string) error {
+	roles, err := readRecentRolesFile(path)
+	if err != nil {
+		roles = map[string]string{}
+	}
+	roles[samlURL] = roleARN
+
+	data, err := json.MarshalIndent(roles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path, err = homedir.Expand(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
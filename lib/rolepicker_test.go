@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/aws-okta/lib/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+var pickerRoles = saml.AssumableRoles{
+	{Role: "arn:aws:iam::111111111111:role/admin", Principal: "arn:aws:iam::111111111111:saml-provider/okta"},
+	{Role: "arn:aws:iam::111111111111:role/read-only", Principal: "arn:aws:iam::111111111111:saml-provider/okta"},
+	{Role: "arn:aws:iam::222222222222:role/admin", Principal: "arn:aws:iam::222222222222:saml-provider/okta"},
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	assert.True(t, fuzzyMatch("", "prod (111111111111)  admin"))
+	assert.True(t, fuzzyMatch("prodadm", "prod (111111111111)  admin"))
+	assert.True(t, fuzzyMatch("PROD", "prod (111111111111)  admin"))
+	assert.False(t, fuzzyMatch("adminprod", "prod (111111111111)  admin"))
+}
+
+func TestRolePicker(t *testing.T) {
+	aliases := AccountAliases{"111111111111": "prod", "222222222222": "staging"}
+
+	p := newRolePicker(pickerRoles, aliases, "arn:aws:iam::111111111111:role/read-only")
+	assert.Equal(t, "arn:aws:iam::111111111111:role/read-only", p.selectedRole().Role)
+
+	for _, key := range "stag" {
+		done, err := p.handleKey(key)
+		assert.False(t, done)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int{2}, p.matches)
+	assert.Equal(t, "arn:aws:iam::222222222222:role/admin", p.selectedRole().Role)
+
+	// backspaces widen the filter again
+	for i := 0; i < 4; i++ {
+		p.handleKey(127)
+	}
+	for _, key := range "adm" {
+		p.handleKey(key)
+	}
+	assert.Equal(t, []int{0, 2}, p.matches)
+	p.handleKey(keyDown)
+	p.handleKey(keyDown)
+	assert.Equal(t, "arn:aws:iam::222222222222:role/admin", p.selectedRole().Role)
+	p.handleKey(keyUp)
+	assert.Equal(t, "arn:aws:iam::111111111111:role/admin", p.selectedRole().Role)
+
+	var out bytes.Buffer
+	p.render(&out)
+	assert.Contains(t, out.String(), "> prod (111111111111)  admin\r\n")
+	assert.Contains(t, out.String(), "2/3 > adm")
+
+	done, err := p.handleKey('\r')
+	assert.True(t, done)
+	assert.NoError(t, err)
+
+	// nothing to pick
+	p.handleKey('x')
+	done, _ = p.handleKey('\r')
+	assert.False(t, done)
+
+	_, err = p.handleKey(keyCancel)
+	assert.Error(t, err)
+}
+
+func TestRecentRolesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recent-roles.json")
+
+	assert.NoError(t, updateRecentRolesFile(path, "home/amazon_aws/a/1", "arn:aws:iam::111111111111:role/admin"))
+	assert.NoError(t, updateRecentRolesFile(path, "home/amazon_aws/b/2", "arn:aws:iam::222222222222:role/admin"))
+
+	roles, err := readRecentRolesFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"home/amazon_aws/a/1": "arn:aws:iam::111111111111:role/admin",
+		"home/amazon_aws/b/2": "arn:aws:iam::222222222222:role/admin",
+	}, roles)
+}
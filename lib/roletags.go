@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/segmentio/aws-okta/lib/saml"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// DefaultRoleTags is the `role_tags` of profiles that don't set any: roles
+// assumed from the Okta session are tagged with the Okta username
+const DefaultRoleTags = "OktaUser={{.Username}}"
+
+// RoleTagsData is what the values of `role_tags` and `source_identity` are
+// templated with, e.g. `team={{.Attributes.PrincipalTags.team}}`
+type RoleTagsData struct {
+	// Username is the Okta username
+	Username string
+	// Attributes are those of the SAML assertion
+	Attributes saml.SessionAttributes
+}
+
+// roleTags are the session tags and source identity a role is assumed with
+type roleTags struct {
+	Tags              map[string]string
+	TransitiveTagKeys []string
+	SourceIdentity    string
+	// Defaulted is true if the profile sets none of them. Default tags are
+	// dropped if the role's trust policy doesn't allow them, so that roles
+	// without sts:TagSession or sts:SetSourceIdentity can still be assumed.
+	Defaulted bool
+}
+
+// IsZero returns whether there are no tags nor source identity
+func (t roleTags) IsZero() bool {
+	return len(t.Tags) == 0 && len(t.TransitiveTagKeys) == 0 && t.SourceIdentity == ""
+}
+
+// String returns the tags in a stable order, to tell sessions with different
+// tags apart
+func (t roleTags) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	var pairs []string
+	for key, value := range t.Tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	transitive := append([]string(nil), t.TransitiveTagKeys...)
+	sort.Strings(transitive)
+	return strings.Join(pairs, ",") + "\n" + strings.Join(transitive, ",") + "\n" + t.SourceIdentity
+}
+
+// parseRoleTags parses `role_tags`: comma separated `key=value` pairs whose
+// values are templates. Tags whose value is empty are skipped.
+func parseRoleTags(tags string, data RoleTagsData) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, pair := range strings.Split(tags, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid role tag %q, expected key=value", pair)
+		}
+		value, err := expandRoleTagTemplate(strings.TrimSpace(kv[1]), data)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			parsed[strings.TrimSpace(kv[0])] = value
+		}
+	}
+	return parsed, nil
+}
+
+func expandRoleTagTemplate(text string, data RoleTagsData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", xerrors.Errorf("parsing %q: %w", text, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", xerrors.Errorf("expanding %q: %w", text, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// addRoleTags adds tags to the AssumeRole request req. The vendored
+// aws-sdk-go predates the Tags, TransitiveTagKeys and SourceIdentity
+// parameters of AssumeRole, so they are added to the request's form body
+// once the query protocol has built it, and before it is signed.
+func addRoleTags(req *request.Request, tags roleTags) {
+	if tags.IsZero() {
+		return
+	}
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			r.Error = err
+			return
+		}
+
+		keys := make([]string, 0, len(tags.Tags))
+		for key := range tags.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			form.Set(fmt.Sprintf("Tags.member.%d.Key", i+1), key)
+			form.Set(fmt.Sprintf("Tags.member.%d.Value", i+1), tags.Tags[key])
+		}
+		for i, key := range tags.TransitiveTagKeys {
+			form.Set(fmt.Sprintf("TransitiveTagKeys.member.%d", i+1), key)
+		}
+		if tags.SourceIdentity != "" {
+			form.Set("SourceIdentity", tags.SourceIdentity)
+		}
+		r.SetBufferBody([]byte(form.Encode()))
+	})
+}
+
+// isRoleTagsRejected reports whether STS refused to assume a role because of
+// its tags: when the role's trust policy doesn't allow tagging its sessions
+// or setting their source identity, or the tags or source identity aren't
+// valid, as when the Okta username isn't a valid source identity. Other
+// refusals, such as for a wrong MFA code, aren't.
+func isRoleTagsRejected(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	message := strings.ToLower(aerr.Message())
+	switch aerr.Code() {
+	case "AccessDenied":
+		return strings.Contains(message, "sts:tagsession") || strings.Contains(message, "sts:setsourceidentity")
+	case "ValidationError":
+		return strings.Contains(message, "tag") || strings.Contains(message, "sourceidentity")
+	}
+	return false
+}
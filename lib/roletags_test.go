@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/segmentio/aws-okta/lib/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoleTags(t *testing.T) {
+	data := RoleTagsData{
+		Username: "alice@example.com",
+		Attributes: saml.SessionAttributes{
+			PrincipalTags: map[string]string{"team": "platform"},
+		},
+	}
+
+	tags, err := parseRoleTags(DefaultRoleTags, data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"OktaUser": "alice@example.com"}, tags)
+	}
+
+	tags, err = parseRoleTags("team={{.Attributes.PrincipalTags.team}}, project = {{.Attributes.PrincipalTags.project}} ,env=dev", data)
+	if assert.NoError(t, err) {
+		// tags that expand to nothing are skipped
+		assert.Equal(t, map[string]string{"team": "platform", "env": "dev"}, tags)
+	}
+
+	tags, err = parseRoleTags("", data)
+	if assert.NoError(t, err) {
+		assert.Empty(t, tags)
+	}
+
+	_, err = parseRoleTags("team", data)
+	assert.Error(t, err)
+
+	_, err = parseRoleTags("team={{.Nope", data)
+	assert.Error(t, err)
+}
+
+func TestRoleTagsString(t *testing.T) {
+	assert.Equal(t, "", roleTags{Defaulted: true}.String())
+	assert.Equal(t,
+		roleTags{Tags: map[string]string{"a": "1", "b": "2"}}.String(),
+		roleTags{Tags: map[string]string{"b": "2", "a": "1"}}.String())
+	assert.NotEqual(t,
+		roleTags{Tags: map[string]string{"a": "1"}}.String(),
+		roleTags{Tags: map[string]string{"a": "1"}, SourceIdentity: "alice"}.String())
+}
+
+func TestAddRoleTags(t *testing.T) {
+	sess := aws_session.Must(aws_session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", "token"),
+	}))
+	req, _ := sts.New(sess).AssumeRoleRequest(&sts.AssumeRoleInput{
+		RoleArn:         aws.String("arn:aws:iam::123456789012:role/admin"),
+		RoleSessionName: aws.String("alice"),
+	})
+	addRoleTags(req, roleTags{
+		Tags:              map[string]string{"team": "platform", "OktaUser": "alice@example.com"},
+		TransitiveTagKeys: []string{"team"},
+		SourceIdentity:    "alice@example.com",
+	})
+	if !assert.NoError(t, req.Build()) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.GetBody())
+	if !assert.NoError(t, err) {
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "AssumeRole", form.Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/admin", form.Get("RoleArn"))
+	assert.Equal(t, "OktaUser", form.Get("Tags.member.1.Key"))
+	assert.Equal(t, "alice@example.com", form.Get("Tags.member.1.Value"))
+	assert.Equal(t, "team", form.Get("Tags.member.2.Key"))
+	assert.Equal(t, "platform", form.Get("Tags.member.2.Value"))
+	assert.Equal(t, "team", form.Get("TransitiveTagKeys.member.1"))
+	assert.Equal(t, "alice@example.com", form.Get("SourceIdentity"))
+}
+
+func TestIsRoleTagsRejected(t *testing.T) {
+	for _, tc := range []struct {
+		err      error
+		rejected bool
+	}{
+		{awserr.New("AccessDenied", "User: arn:aws:sts::123456789012:assumed-role/okta/user is not authorized to perform: sts:TagSession on resource: arn:aws:iam::123456789012:role/admin", nil), true},
+		{awserr.New("AccessDenied", "User: arn:aws:sts::123456789012:assumed-role/okta/user is not authorized to perform: sts:SetSourceIdentity on resource: arn:aws:iam::123456789012:role/admin", nil), true},
+		{awserr.New("ValidationError", "1 validation error detected: Value 'user name' at 'sourceIdentity' failed to satisfy constraint", nil), true},
+		{awserr.New("ValidationError", "1 validation error detected: Value 'a*b' at 'tags.1.member.value' failed to satisfy constraint", nil), true},
+		// retrying these without tags wouldn't help
+		{awserr.New("AccessDenied", "MultiFactorAuthentication failed with invalid MFA one time pass code. ", nil), false},
+		{awserr.New("AccessDenied", "User: arn:aws:sts::123456789012:assumed-role/okta/user is not authorized to perform: sts:AssumeRole on resource: arn:aws:iam::123456789012:role/admin", nil), false},
+		{awserr.New("ValidationError", "The requested DurationSeconds exceeds the MaxSessionDuration set for this role.", nil), false},
+	} {
+		assert.Equal(t, tc.rejected, isRoleTagsRejected(tc.err), tc.err.Error())
+	}
+}
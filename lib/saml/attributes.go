@@ -0,0 +1,79 @@
+package saml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RoleAttribute              = "https://aws.amazon.com/SAML/Attributes/Role"
+	SessionDurationAttribute   = "https://aws.amazon.com/SAML/Attributes/SessionDuration"
+	RoleSessionNameAttribute   = "https://aws.amazon.com/SAML/Attributes/RoleSessionName"
+	SourceIdentityAttribute    = "https://aws.amazon.com/SAML/Attributes/SourceIdentity"
+	TransitiveTagKeysAttribute = "https://aws.amazon.com/SAML/Attributes/TransitiveTagKeys"
+	// PrincipalTagAttributePrefix is followed by the tag key
+	PrincipalTagAttributePrefix = "https://aws.amazon.com/SAML/Attributes/PrincipalTag:"
+)
+
+// SessionAttributes are the attributes of an assertion that AWS applies to
+// the sessions created with it
+type SessionAttributes struct {
+	RoleSessionName   string            `json:",omitempty"`
+	SourceIdentity    string            `json:",omitempty"`
+	PrincipalTags     map[string]string `json:",omitempty"`
+	TransitiveTagKeys []string          `json:",omitempty"`
+}
+
+// AttributeValues returns the values of the assertion's attribute named name
+func (r *Response) AttributeValues(name string) []string {
+	var values []string
+	for _, a := range r.Assertion.AttributeStatement.Attributes {
+		if a.Name != name {
+			continue
+		}
+		for _, v := range a.AttributeValues {
+			values = append(values, strings.TrimSpace(v.Value))
+		}
+	}
+	return values
+}
+
+// SessionDuration returns the session duration set by the IdP, or false if
+// it didn't set one
+func (r *Response) SessionDuration() (time.Duration, bool, error) {
+	values := r.AttributeValues(SessionDurationAttribute)
+	if len(values) == 0 {
+		return 0, false, nil
+	}
+	seconds, err := strconv.Atoi(values[0])
+	if err != nil || seconds <= 0 {
+		return 0, false, fmt.Errorf("invalid %s attribute %q", SessionDurationAttribute, values[0])
+	}
+	return time.Duration(seconds) * time.Second, true, nil
+}
+
+// SessionAttributes returns the session attributes set by the IdP
+func (r *Response) SessionAttributes() SessionAttributes {
+	var attrs SessionAttributes
+	if values := r.AttributeValues(RoleSessionNameAttribute); len(values) > 0 {
+		attrs.RoleSessionName = values[0]
+	}
+	if values := r.AttributeValues(SourceIdentityAttribute); len(values) > 0 {
+		attrs.SourceIdentity = values[0]
+	}
+	attrs.TransitiveTagKeys = r.AttributeValues(TransitiveTagKeysAttribute)
+
+	for _, a := range r.Assertion.AttributeStatement.Attributes {
+		if !strings.HasPrefix(a.Name, PrincipalTagAttributePrefix) || len(a.AttributeValues) == 0 {
+			continue
+		}
+		if attrs.PrincipalTags == nil {
+			attrs.PrincipalTags = map[string]string{}
+		}
+		key := strings.TrimPrefix(a.Name, PrincipalTagAttributePrefix)
+		attrs.PrincipalTags[key] = strings.TrimSpace(a.AttributeValues[0].Value)
+	}
+	return attrs
+}
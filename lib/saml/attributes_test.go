@@ -0,0 +1,47 @@
+package saml
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sessionAttributesResponse = `<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol">` +
+	`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion">` +
+	`<saml2:AttributeStatement>` +
+	`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/RoleSessionName">` +
+	`<saml2:AttributeValue>someone@example.com</saml2:AttributeValue>` +
+	`</saml2:Attribute>` +
+	`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/SourceIdentity">` +
+	`<saml2:AttributeValue>someone</saml2:AttributeValue>` +
+	`</saml2:Attribute>` +
+	`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/PrincipalTag:team">` +
+	`<saml2:AttributeValue>infra</saml2:AttributeValue>` +
+	`</saml2:Attribute>` +
+	`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/PrincipalTag:cost-center">` +
+	`<saml2:AttributeValue> 1234 </saml2:AttributeValue>` +
+	`</saml2:Attribute>` +
+	`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/TransitiveTagKeys">` +
+	`<saml2:AttributeValue>team</saml2:AttributeValue>` +
+	`<saml2:AttributeValue>cost-center</saml2:AttributeValue>` +
+	`</saml2:Attribute>` +
+	`</saml2:AttributeStatement>` +
+	`</saml2:Assertion>` +
+	`</saml2p:Response>`
+
+func TestSessionAttributes(t *testing.T) {
+	var resp Response
+	if err := xml.Unmarshal([]byte(sessionAttributesResponse), &resp); err != nil {
+		t.Fatalf("parsing response: %s", err)
+	}
+
+	assert.Equal(t, SessionAttributes{
+		RoleSessionName:   "someone@example.com",
+		SourceIdentity:    "someone",
+		PrincipalTags:     map[string]string{"team": "infra", "cost-center": "1234"},
+		TransitiveTagKeys: []string{"team", "cost-center"},
+	}, resp.SessionAttributes())
+
+	assert.Equal(t, SessionAttributes{}, (&Response{}).SessionAttributes())
+}
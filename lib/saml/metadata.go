@@ -0,0 +1,82 @@
+package saml
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+type metadataKeyDescriptor struct {
+	Use              string   `xml:"use,attr"`
+	X509Certificates []string `xml:"KeyInfo>X509Data>X509Certificate"`
+}
+
+type entityDescriptor struct {
+	KeyDescriptors []metadataKeyDescriptor `xml:"IDPSSODescriptor>KeyDescriptor"`
+}
+
+// ParseCertificates returns the IdP signing certificates in data, which is
+// either the IdP's SAML metadata or PEM-encoded certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return parsePEMCertificates(data)
+	}
+	return parseMetadata(data)
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certificates, nil
+}
+
+func parseMetadata(data []byte) ([]*x509.Certificate, error) {
+	var metadata entityDescriptor
+	if err := xml.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+
+	var certificates []*x509.Certificate
+	for _, descriptor := range metadata.KeyDescriptors {
+		// keys without a use are for both signing and encryption
+		if descriptor.Use != "" && descriptor.Use != "signing" {
+			continue
+		}
+		for _, encoded := range descriptor.X509Certificates {
+			der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+			if err != nil {
+				return nil, err
+			}
+			certificate, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no signing certificates found in SAML metadata")
+	}
+	return certificates, nil
+}
@@ -19,6 +19,7 @@ type Response struct {
 	Version      string `xml:"Version,attr"`
 	IssueInstant string `xml:"IssueInstant,attr"`
 	InResponseTo string `xml:"InResponseTo,attr"`
+	Issuer       string `xml:"Issuer"`
 
 	Assertion Assertion `xml:"Assertion"`
 	Status    Status    `xml:"Status"`
@@ -34,6 +35,7 @@ type Assertion struct {
 	XSI                string `xml:"xmlns:xsi,attr"`
 	SAML               string `xml:"saml,attr"`
 	IssueInstant       string `xml:"IssueInstant,attr"`
+	Issuer             string `xml:"Issuer"`
 	Subject            Subject
 	Conditions         Conditions
 	AttributeStatement AttributeStatement
@@ -43,6 +45,7 @@ type Conditions struct {
 	XMLName      xml.Name
 	NotBefore    string `xml:",attr"`
 	NotOnOrAfter string `xml:",attr"`
+	Audience     string `xml:"AudienceRestriction>Audience"`
 }
 
 type Subject struct {
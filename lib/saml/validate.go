@@ -0,0 +1,231 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+const (
+	// StatusSuccess is the only status code of a response that can be used
+	StatusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+
+	// DefaultClockSkew is how far the IdP's clock may be off from ours when
+	// checking an assertion's Conditions
+	DefaultClockSkew = 3 * time.Minute
+
+	protocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	assertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+)
+
+// ValidationOptions configures Validate
+type ValidationOptions struct {
+	// Certificates are the IdP's signing certificates. If empty, the
+	// signature isn't checked.
+	Certificates []*x509.Certificate
+	// ClockSkew defaults to DefaultClockSkew
+	ClockSkew time.Duration
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// StatusError is returned for responses whose status isn't StatusSuccess
+type StatusError struct {
+	Code string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("SAML response status is %q", e.Code)
+}
+
+// ConditionsError is returned for assertions that aren't valid yet, have
+// expired, or whose conditions can't be parsed
+type ConditionsError struct {
+	NotBefore    string
+	NotOnOrAfter string
+	Now          time.Time
+	// Err is the error parsing the conditions, if any
+	Err error
+}
+
+func (e *ConditionsError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid SAML assertion conditions: %s", e.Err)
+	}
+	return fmt.Sprintf("SAML assertion is only valid from %q until %q; it is now %s",
+		e.NotBefore, e.NotOnOrAfter, e.Now.UTC().Format(time.RFC3339))
+}
+
+func (e *ConditionsError) Unwrap() error {
+	return e.Err
+}
+
+// SignatureError is returned for responses that aren't signed by one of the
+// IdP's certificates
+type SignatureError struct {
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("invalid SAML signature: %s", e.Err)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks the status, signature and conditions of the SAML response
+// in data (the decoded XML), and returns the response
+//
+// When the signature is checked, the returned response is parsed from the
+// signed XML only, so that anything outside of the signature is ignored.
+func Validate(data []byte, opts ValidationOptions) (*Response, error) {
+	var resp Response
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Status.StatusCode.Value != StatusSuccess {
+		return nil, &StatusError{Code: resp.Status.StatusCode.Value}
+	}
+
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+
+	if len(opts.Certificates) > 0 {
+		signed, err := verifySignature(data, opts.Certificates, now())
+		if err != nil {
+			return nil, &SignatureError{Err: err}
+		}
+		resp = *signed
+	}
+
+	skew := opts.ClockSkew
+	if skew == 0 {
+		skew = DefaultClockSkew
+	}
+	if err := checkConditions(resp.Assertion.Conditions, now(), skew); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// verifySignature checks the signature of either the whole response or its
+// assertion, and returns the response parsed from the signed XML
+func verifySignature(data []byte, certificates []*x509.Certificate, now time.Time) (*Response, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" || root.NamespaceURI() != protocolNamespace {
+		return nil, errors.New("not a SAML response")
+	}
+
+	// a second assertion is how signature wrapping attacks sneak in unsigned
+	// attributes
+	var assertion *etree.Element
+	for _, child := range root.ChildElements() {
+		if child.Tag == "Assertion" && child.NamespaceURI() == assertionNamespace {
+			if assertion != nil {
+				return nil, errors.New("SAML response has more than one assertion")
+			}
+			assertion = child
+		}
+	}
+	if assertion == nil {
+		return nil, errors.New("SAML response has no assertion")
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certificates})
+	ctx.Clock = dsig.NewFakeClockAt(now)
+
+	if hasSignature(root) {
+		validated, err := ctx.Validate(root)
+		if err != nil {
+			return nil, err
+		}
+		var resp Response
+		if err := unmarshalElement(validated, &resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	validated, err := ctx.Validate(assertion)
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	resp.Assertion = Assertion{}
+	if err := unmarshalElement(validated, &resp.Assertion); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// hasSignature reports whether el has an enveloped signature of its own
+func hasSignature(el *etree.Element) bool {
+	for _, child := range el.ChildElements() {
+		if child.Tag == "Signature" && child.NamespaceURI() == dsig.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func unmarshalElement(el *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+func checkConditions(conditions Conditions, now time.Time, skew time.Duration) error {
+	conditionsErr := &ConditionsError{
+		NotBefore:    conditions.NotBefore,
+		NotOnOrAfter: conditions.NotOnOrAfter,
+		Now:          now,
+	}
+
+	if conditions.NotBefore != "" {
+		notBefore, err := time.Parse(time.RFC3339Nano, conditions.NotBefore)
+		if err != nil {
+			conditionsErr.Err = xerrors.Errorf("parsing NotBefore: %w", err)
+			return conditionsErr
+		}
+		if now.Add(skew).Before(notBefore) {
+			return conditionsErr
+		}
+	}
+
+	if conditions.NotOnOrAfter != "" {
+		notOnOrAfter, err := time.Parse(time.RFC3339Nano, conditions.NotOnOrAfter)
+		if err != nil {
+			conditionsErr.Err = xerrors.Errorf("parsing NotOnOrAfter: %w", err)
+			return conditionsErr
+		}
+		if !now.Add(-skew).Before(notOnOrAfter) {
+			return conditionsErr
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/stretchr/testify/assert"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+const testRole = "arn:aws:iam::123456789012:role/admin,arn:aws:iam::123456789012:saml-provider/okta"
+
+// the test certificates are only valid around the current time
+var testNow = time.Now().UTC().Truncate(time.Second)
+
+func testResponse(status string) string {
+	return `<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol" ID="response-1" Version="2.0">` +
+		`<saml2p:Status><saml2p:StatusCode Value="` + status + `"/></saml2p:Status>` +
+		`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="assertion-1" Version="2.0">` +
+		`<saml2:Conditions NotBefore="` + testNow.Add(-5*time.Minute).Format(time.RFC3339Nano) +
+		`" NotOnOrAfter="` + testNow.Add(5*time.Minute).Format(time.RFC3339Nano) + `"/>` +
+		`<saml2:AttributeStatement>` +
+		`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">` +
+		`<saml2:AttributeValue>` + testRole + `</saml2:AttributeValue>` +
+		`</saml2:Attribute>` +
+		`</saml2:AttributeStatement>` +
+		`</saml2:Assertion>` +
+		`</saml2p:Response>`
+}
+
+// sign signs the response, or only its assertion, with a new key, and
+// returns the signed XML and the key's certificate
+func sign(t *testing.T, response string, wholeResponse bool) (string, *x509.Certificate) {
+	keyStore := dsig.RandomKeyStoreForTest()
+	_, der, err := keyStore.GetKeyPair()
+	if err != nil {
+		t.Fatalf("getting key: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %s", err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(response); err != nil {
+		t.Fatalf("parsing response: %s", err)
+	}
+	ctx := dsig.NewDefaultSigningContext(keyStore)
+	if wholeResponse {
+		signed, err := ctx.SignEnveloped(doc.Root())
+		if err != nil {
+			t.Fatalf("signing response: %s", err)
+		}
+		doc.SetRoot(signed)
+	} else {
+		// like IdPs do, sign the assertion before adding it to the response
+		assertion := doc.Root().RemoveChild(doc.Root().SelectElement("Assertion")).(*etree.Element)
+		signed, err := ctx.SignEnveloped(assertion)
+		if err != nil {
+			t.Fatalf("signing assertion: %s", err)
+		}
+		doc.Root().AddChild(signed)
+	}
+
+	signed, err := doc.WriteToString()
+	if err != nil {
+		t.Fatalf("writing response: %s", err)
+	}
+	return signed, cert
+}
+
+func testOptions(certs ...*x509.Certificate) ValidationOptions {
+	return ValidationOptions{
+		Certificates: certs,
+		Now:          func() time.Time { return testNow },
+	}
+}
+
+func TestValidateStatus(t *testing.T) {
+	_, err := Validate([]byte(testResponse("urn:oasis:names:tc:SAML:2.0:status:Responder")), testOptions())
+	assert.Equal(t, &StatusError{Code: "urn:oasis:names:tc:SAML:2.0:status:Responder"}, err)
+
+	resp, err := Validate([]byte(testResponse(StatusSuccess)), testOptions())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "assertion-1", resp.Assertion.ID)
+	}
+}
+
+func TestValidateConditions(t *testing.T) {
+	response := []byte(testResponse(StatusSuccess))
+
+	for _, tc := range []struct {
+		now   time.Time
+		skew  time.Duration
+		valid bool
+	}{
+		{now: testNow, valid: true},
+		{now: testNow.Add(-6 * time.Minute), valid: true},
+		{now: testNow.Add(-9 * time.Minute), valid: false},
+		{now: testNow.Add(-9 * time.Minute), skew: 5 * time.Minute, valid: true},
+		{now: testNow.Add(7 * time.Minute), valid: true},
+		{now: testNow.Add(8 * time.Minute), valid: false},
+	} {
+		t.Run(fmt.Sprintf("%s with skew %s", tc.now.Sub(testNow), tc.skew), func(t *testing.T) {
+			now := tc.now
+			_, err := Validate(response, ValidationOptions{
+				ClockSkew: tc.skew,
+				Now:       func() time.Time { return now },
+			})
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.IsType(t, &ConditionsError{}, err)
+			}
+		})
+	}
+}
+
+func TestValidateConditionsParseError(t *testing.T) {
+	for _, attr := range []string{"NotBefore", "NotOnOrAfter"} {
+		t.Run(attr, func(t *testing.T) {
+			response := strings.Replace(testResponse(StatusSuccess), attr+`="`, attr+`="yesterday`, 1)
+			_, err := Validate([]byte(response), ValidationOptions{})
+
+			var conditionsErr *ConditionsError
+			if assert.True(t, xerrors.As(err, &conditionsErr), "got %v", err) {
+				var parseErr *time.ParseError
+				assert.True(t, xerrors.As(conditionsErr, &parseErr), "got %v", conditionsErr)
+			}
+		})
+	}
+}
+
+func TestValidateSignature(t *testing.T) {
+	for _, wholeResponse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("whole response %t", wholeResponse), func(t *testing.T) {
+			signed, cert := sign(t, testResponse(StatusSuccess), wholeResponse)
+			_, otherCert := sign(t, testResponse(StatusSuccess), wholeResponse)
+
+			resp, err := Validate([]byte(signed), testOptions(otherCert, cert))
+			if assert.NoError(t, err) {
+				assert.Equal(t, testRole, resp.Assertion.AttributeStatement.Attributes[0].AttributeValues[0].Value)
+			}
+
+			_, err = Validate([]byte(signed), testOptions(otherCert))
+			assert.IsType(t, &SignatureError{}, err)
+
+			tampered := strings.Replace(signed, "role/admin", "role/other", 1)
+			_, err = Validate([]byte(tampered), testOptions(cert))
+			assert.IsType(t, &SignatureError{}, err)
+		})
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		_, cert := sign(t, testResponse(StatusSuccess), false)
+		_, err := Validate([]byte(testResponse(StatusSuccess)), testOptions(cert))
+		assert.IsType(t, &SignatureError{}, err)
+	})
+
+	t.Run("wrapped assertion", func(t *testing.T) {
+		signed, cert := sign(t, testResponse(StatusSuccess), false)
+		injected := `<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="evil"></saml2:Assertion>`
+		wrapped := strings.Replace(signed, "</saml2p:Status>", "</saml2p:Status>"+injected, 1)
+		_, err := Validate([]byte(wrapped), testOptions(cert))
+		assert.IsType(t, &SignatureError{}, err)
+	})
+}
+
+func TestParseCertificates(t *testing.T) {
+	_, cert := sign(t, testResponse(StatusSuccess), false)
+	encoded := base64.StdEncoding.EncodeToString(cert.Raw)
+
+	metadata := `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="http://www.okta.com/exk1">` +
+		`<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">` +
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">` +
+		`<ds:X509Data><ds:X509Certificate>` + encoded[:40] + "\n" + encoded[40:] + `</ds:X509Certificate></ds:X509Data>` +
+		`</ds:KeyInfo></md:KeyDescriptor>` +
+		`</md:IDPSSODescriptor>` +
+		`</md:EntityDescriptor>`
+	certs, err := ParseCertificates([]byte(metadata))
+	if assert.NoError(t, err) && assert.Len(t, certs, 1) {
+		assert.True(t, cert.Equal(certs[0]))
+	}
+
+	certs, err = ParseCertificates(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	if assert.NoError(t, err) && assert.Len(t, certs, 1) {
+		assert.True(t, cert.Equal(certs[0]))
+	}
+
+	_, err = ParseCertificates([]byte(`<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata"/>`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/segmentio/aws-okta/lib/saml"
+	"github.com/segmentio/aws-okta/sessioncache"
+	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// SAMLAssertionExpiryWindow is how long before its NotOnOrAfter a cached SAML
+// assertion stops being used, to leave time to send it to STS
+const SAMLAssertionExpiryWindow = 30 * time.Second
+
+// SAMLAssertionCache keeps SAML assertions until they expire, so that several
+// roles behind the same aws_saml_url can be assumed with a single Okta round
+// trip and MFA prompt
+//
+// Assertions are kept in memory, and if Sessions is set, in the session cache
+// as well, so that other runs of aws-okta can use them without a keyring item
+// of their own to unlock.
+type SAMLAssertionCache struct {
+	Sessions SessionCacheInterface
+
+	mu         sync.Mutex
+	assertions map[string]cachedSAMLAssertion
+}
+
+type cachedSAMLAssertion struct {
+	RawData []byte
+	Expires time.Time
+}
+
+// samlAssertionCacheKey returns the cache key, which is also the session cache
+// key, of the assertion for samlURL; it is hashed as keyring item keys may be
+// visible to other applications
+func samlAssertionCacheKey(domain, samlURL, username string) string {
+	sum := sha256.Sum256([]byte(domain + "\n" + samlURL + "\n" + username))
+	return "okta-saml-assertion-" + hex.EncodeToString(sum[:8])
+}
+
+// samlAssertionExpiry returns when the assertion in resp expires, or false if
+// it doesn't say
+func samlAssertionExpiry(resp *saml.Response) (time.Time, bool) {
+	notOnOrAfter := resp.Assertion.Conditions.NotOnOrAfter
+	if notOnOrAfter == "" {
+		notOnOrAfter = resp.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.NotOnOrAfter
+	}
+	expires, err := time.Parse(time.RFC3339Nano, notOnOrAfter)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// Get returns the cached assertion for key, if it hasn't expired
+func (c *SAMLAssertionCache) Get(key string) (*SAMLAssertion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.assertions[key]
+	if !ok && c.Sessions != nil {
+		session, err := c.Sessions.Get(sessioncache.RawKey(key))
+		if err == nil && session.SAMLAssertion != nil && session.Expiration != nil {
+			cached = cachedSAMLAssertion{
+				RawData: session.SAMLAssertion,
+				Expires: *session.Expiration,
+			}
+			ok = true
+		} else if err != nil && !xerrors.Is(err, keyring.ErrKeyNotFound) && !xerrors.Is(err, sessioncache.ErrSessionExpired) {
+			log.Debugf("failed reading SAML assertion %s from session cache: %s", key, err)
+		}
+	}
+	if !ok {
+		log.Debugf("SAML assertion cache get `%s`: miss", key)
+		return nil, false
+	}
+	if time.Now().Add(SAMLAssertionExpiryWindow).After(cached.Expires) {
+		log.Debugf("SAML assertion cache get `%s`: expired", key)
+		return nil, false
+	}
+
+	data, err := decodeSAMLResponse(cached.RawData)
+	if err != nil {
+		log.Debugf("SAML assertion cache get `%s`: invalid: %s", key, err)
+		return nil, false
+	}
+	assertion := &SAMLAssertion{RawData: cached.RawData}
+	if err := xml.Unmarshal(data, &assertion.Resp); err != nil {
+		log.Debugf("SAML assertion cache get `%s`: invalid: %s", key, err)
+		return nil, false
+	}
+
+	log.Debugf("SAML assertion cache get `%s`: hit, expires %s", key, cached.Expires)
+	return assertion, true
+}
+
+// Put caches assertion until its NotOnOrAfter; assertions without one aren't
+// cached
+func (c *SAMLAssertionCache) Put(key string, assertion *SAMLAssertion) error {
+	expires, ok := samlAssertionExpiry(assertion.Resp)
+	if !ok {
+		log.Debugf("SAML assertion has no NotOnOrAfter; not caching it")
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.assertions == nil {
+		c.assertions = map[string]cachedSAMLAssertion{}
+	}
+	c.assertions[key] = cachedSAMLAssertion{
+		RawData: assertion.RawData,
+		Expires: expires,
+	}
+
+	if c.Sessions == nil {
+		return nil
+	}
+	// the session cache prunes the assertion once it expires
+	session := &sessioncache.Session{
+		Name:          "SAML assertion",
+		SAMLAssertion: assertion.RawData,
+		Credentials:   sts.Credentials{Expiration: &expires},
+	}
+	if err := c.Sessions.Put(sessioncache.RawKey(key), session); err != nil {
+		return xerrors.Errorf("writing SAML assertion %s to session cache: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes the assertion for key, e.g. after STS rejected it
+func (c *SAMLAssertionCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.assertions, key)
+	if c.Sessions != nil {
+		if err := c.Sessions.Delete(sessioncache.RawKey(key)); err != nil && !xerrors.Is(err, keyring.ErrKeyNotFound) {
+			log.Debugf("failed removing SAML assertion %s from session cache: %s", key, err)
+		}
+	}
+}
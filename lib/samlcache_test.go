@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/segmentio/aws-okta/sessioncache"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSAMLAssertion(t *testing.T, notOnOrAfter time.Time) *SAMLAssertion {
+	xml := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="response-1">` +
+		`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="assertion-1">` +
+		`<saml2:Conditions NotOnOrAfter="` + notOnOrAfter.UTC().Format(time.RFC3339Nano) + `"/>` +
+		`</saml2:Assertion>` +
+		`</samlp:Response>`
+	var assertion SAMLAssertion
+	if err := ParseSAML([]byte(samlPage(xml)), &assertion); err != nil {
+		t.Fatalf("parsing SAML: %s", err)
+	}
+	return &assertion
+}
+
+func TestSAMLAssertionCache(t *testing.T) {
+	kr := keyring.NewArrayKeyring([]keyring.Item{})
+	key := samlAssertionCacheKey("example.okta.com", "home/amazon_aws/app/1", "someone")
+	assertion := testSAMLAssertion(t, time.Now().Add(5*time.Minute))
+
+	sessions := &sessioncache.KrItemPerSessionStore{Keyring: kr}
+	cache := &SAMLAssertionCache{Sessions: sessions}
+	_, ok := cache.Get(key)
+	assert.False(t, ok)
+
+	if err := cache.Put(key, assertion); err != nil {
+		t.Fatalf("caching assertion: %s", err)
+	}
+	cached, ok := cache.Get(key)
+	if assert.True(t, ok) {
+		assert.Equal(t, assertion.RawData, cached.RawData)
+		assert.Equal(t, "assertion-1", cached.Resp.Assertion.ID)
+	}
+
+	// another process shares the assertion through the session cache
+	cached, ok = (&SAMLAssertionCache{Sessions: sessions}).Get(key)
+	if assert.True(t, ok) {
+		assert.Equal(t, "assertion-1", cached.Resp.Assertion.ID)
+	}
+
+	otherKey := samlAssertionCacheKey("example.okta.com", "home/amazon_aws/app/2", "someone")
+	_, ok = cache.Get(otherKey)
+	assert.False(t, ok)
+
+	cache.Delete(key)
+	_, ok = cache.Get(key)
+	assert.False(t, ok)
+	_, err := kr.Get(key)
+	assert.Equal(t, keyring.ErrKeyNotFound, err)
+
+	// it is listed, and so cleared and pruned, with the sessions
+	if err := cache.Put(key, assertion); err != nil {
+		t.Fatalf("caching assertion: %s", err)
+	}
+	entries, err := sessions.List()
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, key, entries[0].Key)
+		assert.Equal(t, assertion.RawData, entries[0].SAMLAssertion)
+	}
+}
+
+func TestSAMLAssertionCacheExpiry(t *testing.T) {
+	key := samlAssertionCacheKey("example.okta.com", "home/amazon_aws/app/1", "someone")
+	cache := &SAMLAssertionCache{}
+
+	// too close to NotOnOrAfter to make it to STS
+	if err := cache.Put(key, testSAMLAssertion(t, time.Now().Add(SAMLAssertionExpiryWindow/2))); err != nil {
+		t.Fatalf("caching assertion: %s", err)
+	}
+	_, ok := cache.Get(key)
+	assert.False(t, ok)
+
+	// no NotOnOrAfter
+	page := samlPage(samlResponseXML)
+	var assertion SAMLAssertion
+	if err := ParseSAML([]byte(page), &assertion); err != nil {
+		t.Fatalf("parsing SAML: %s", err)
+	}
+	cache = &SAMLAssertionCache{}
+	if err := cache.Put(key, &assertion); err != nil {
+		t.Fatalf("caching assertion: %s", err)
+	}
+	_, ok = cache.Get(key)
+	assert.False(t, ok)
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// XML returns the decoded SAML response
+func (a *SAMLAssertion) XML() ([]byte, error) {
+	return decodeSAMLResponse(a.RawData)
+}
+
+// Base64 returns the SAML response as sent to AWS, in standard base64
+func (a *SAMLAssertion) Base64() (string, error) {
+	data, err := a.XML()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// WriteSAMLXML writes the SAML response, indented
+func WriteSAMLXML(w io.Writer, a *SAMLAssertion) error {
+	data, err := a.XML()
+	if err != nil {
+		return err
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return err
+	}
+	doc.Indent(2)
+	_, err = doc.WriteTo(w)
+	return err
+}
+
+// WriteSAMLSummary writes a human readable summary of the SAML response: who
+// it is for, when it is valid, its attributes, and the roles it can assume
+// grouped by account, named with aliases
+func WriteSAMLSummary(w io.Writer, a *SAMLAssertion, aliases AccountAliases) error {
+	resp := a.Resp
+	assertion := resp.Assertion
+
+	issuer := assertion.Issuer
+	if issuer == "" {
+		issuer = resp.Issuer
+	}
+	fmt.Fprintf(w, "Issuer:        %s\n", issuer)
+	fmt.Fprintf(w, "Subject:       %s\n", strings.TrimSpace(assertion.Subject.NameID.Value))
+	fmt.Fprintf(w, "Status:        %s\n", resp.Status.StatusCode.Value)
+	fmt.Fprintf(w, "Issued:        %s\n", assertion.IssueInstant)
+	fmt.Fprintf(w, "Not before:    %s\n", assertion.Conditions.NotBefore)
+	fmt.Fprintf(w, "Not on/after:  %s\n", assertion.Conditions.NotOnOrAfter)
+	if assertion.Conditions.Audience != "" {
+		fmt.Fprintf(w, "Audience:      %s\n", assertion.Conditions.Audience)
+	}
+
+	fmt.Fprintln(w, "\nAttributes:")
+	for _, attribute := range assertion.AttributeStatement.Attributes {
+		fmt.Fprintf(w, "  %s\n", attribute.Name)
+		for _, value := range attribute.AttributeValues {
+			fmt.Fprintf(w, "    %s\n", strings.TrimSpace(value.Value))
+		}
+	}
+
+	roles, err := GetAssumableRolesFromSAML(resp)
+	if err != nil {
+		return err
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Role < roles[j].Role
+	})
+
+	fmt.Fprintln(w, "\nRoles:")
+	var previousAccountID string
+	for i, role := range roles {
+		accountID, roleName := accountIDAndRoleFromRoleARN(role.Role)
+		if i == 0 || accountID != previousAccountID {
+			fmt.Fprintf(w, "  Account: %s\n", aliases.Name(accountID))
+		}
+		previousAccountID = accountID
+		fmt.Fprintf(w, "    %s (%s)\n", roleName, role.Principal)
+	}
+	return nil
+}
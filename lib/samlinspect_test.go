@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const inspectSAMLResponseXML = `<saml2p:Response xmlns:saml2p="urn:oasis:names:tc:SAML:2.0:protocol" ID="response-1">` +
+	`<saml2p:Status><saml2p:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></saml2p:Status>` +
+	`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="assertion-1" IssueInstant="2020-03-01T12:00:00.000Z">` +
+	`<saml2:Issuer>http://www.okta.com/exk1</saml2:Issuer>` +
+	`<saml2:Subject><saml2:NameID>someone@example.com</saml2:NameID></saml2:Subject>` +
+	`<saml2:Conditions NotBefore="2020-03-01T11:55:00.000Z" NotOnOrAfter="2020-03-01T12:05:00.000Z">` +
+	`<saml2:AudienceRestriction><saml2:Audience>urn:amazon:webservices</saml2:Audience></saml2:AudienceRestriction>` +
+	`</saml2:Conditions>` +
+	`<saml2:AttributeStatement>` +
+	`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">` +
+	`<saml2:AttributeValue>arn:aws:iam::222222222222:role/dev,arn:aws:iam::222222222222:saml-provider/okta</saml2:AttributeValue>` +
+	`<saml2:AttributeValue>arn:aws:iam::111111111111:role/admin,arn:aws:iam::111111111111:saml-provider/okta</saml2:AttributeValue>` +
+	`<saml2:AttributeValue>arn:aws:iam::111111111111:role/read-only,arn:aws:iam::111111111111:saml-provider/okta</saml2:AttributeValue>` +
+	`</saml2:Attribute>` +
+	`</saml2:AttributeStatement>` +
+	`</saml2:Assertion>` +
+	`</saml2p:Response>`
+
+func TestWriteSAMLSummary(t *testing.T) {
+	var assertion SAMLAssertion
+	if err := ParseSAML([]byte(samlPage(inspectSAMLResponseXML)), &assertion); err != nil {
+		t.Fatalf("parsing SAML: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteSAMLSummary(&out, &assertion, AccountAliases{"111111111111": "prod"}); err != nil {
+		t.Fatalf("writing summary: %s", err)
+	}
+	assert.Equal(t, `Issuer:        http://www.okta.com/exk1
+Subject:       someone@example.com
+Status:        urn:oasis:names:tc:SAML:2.0:status:Success
+Issued:        2020-03-01T12:00:00.000Z
+Not before:    2020-03-01T11:55:00.000Z
+Not on/after:  2020-03-01T12:05:00.000Z
+Audience:      urn:amazon:webservices
+
+Attributes:
+  https://aws.amazon.com/SAML/Attributes/Role
+    arn:aws:iam::222222222222:role/dev,arn:aws:iam::222222222222:saml-provider/okta
+    arn:aws:iam::111111111111:role/admin,arn:aws:iam::111111111111:saml-provider/okta
+    arn:aws:iam::111111111111:role/read-only,arn:aws:iam::111111111111:saml-provider/okta
+
+Roles:
+  Account: prod (111111111111)
+    admin (arn:aws:iam::111111111111:saml-provider/okta)
+    read-only (arn:aws:iam::111111111111:saml-provider/okta)
+  Account: 222222222222
+    dev (arn:aws:iam::222222222222:saml-provider/okta)
+`, out.String())
+
+	raw, err := assertion.Base64()
+	if assert.NoError(t, err) {
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(inspectSAMLResponseXML)), raw)
+	}
+
+	out.Reset()
+	if assert.NoError(t, WriteSAMLXML(&out, &assertion)) {
+		assert.Contains(t, out.String(), "\n  <saml2p:Status>\n    <saml2p:StatusCode")
+	}
+}
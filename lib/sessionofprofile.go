@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/segmentio/aws-okta/sessioncache"
+)
+
+// SessionOfProfile returns whether the cached session entry is one of
+// profile's, which must all be removed for profile to authenticate again. They
+// are the sessions created for profile, the Okta session of its source
+// profile, which is keyed by the source profile's name, and, if profile is a
+// source profile, the sessions derived from its Okta session, whichever
+// profile they were created for.
+func (p Profiles) SessionOfProfile(entry sessioncache.Entry, profile string) bool {
+	if entry.Profile == profile || entry.SourceProfile == profile {
+		return true
+	}
+	source := sourceProfile(profile, p)
+	return strings.HasPrefix(entry.Key, source+" session (") ||
+		strings.HasPrefix(entry.Key, source+" session v2 (")
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/segmentio/aws-okta/sessioncache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionOfProfile(t *testing.T) {
+	profiles := Profiles{
+		"okta":  map[string]string{"role_arn": "arn:aws:iam::111111111111:role/okta"},
+		"dev":   map[string]string{"role_arn": "arn:aws:iam::222222222222:role/dev", "source_profile": "okta"},
+		"prod":  map[string]string{"role_arn": "arn:aws:iam::333333333333:role/prod", "source_profile": "okta"},
+		"other": map[string]string{"role_arn": "arn:aws:iam::444444444444:role/other"},
+	}
+
+	// sessions cached by running dev, prod and other
+	oktaByDev := sessioncache.Entry{
+		Key:     "okta session v2 (0123456789abcdef)",
+		Session: sessioncache.Session{Profile: "dev", SourceProfile: "okta"},
+	}
+	devRole := sessioncache.Entry{
+		Key:     "assumed role session (0123456789)",
+		Session: sessioncache.Session{Profile: "dev", SourceProfile: "okta"},
+	}
+	prodRole := sessioncache.Entry{
+		Key:     "assumed role session (abcdef0123)",
+		Session: sessioncache.Session{Profile: "prod", SourceProfile: "okta"},
+	}
+	otherSession := sessioncache.Entry{
+		Key:     "other session v2 (0123456789abcdef)",
+		Session: sessioncache.Session{Profile: "other", SourceProfile: "other"},
+	}
+	// cached before the profiles were recorded
+	legacyOkta := sessioncache.Entry{Key: "okta session (0123456789)"}
+
+	for _, tc := range []struct {
+		profile string
+		matches []sessioncache.Entry
+		others  []sessioncache.Entry
+	}{
+		{
+			// the chained profile: its role, and the Okta session it was
+			// assumed with, whichever profile created it
+			profile: "prod",
+			matches: []sessioncache.Entry{oktaByDev, prodRole, legacyOkta},
+			others:  []sessioncache.Entry{devRole, otherSession},
+		},
+		{
+			// the source profile: everything derived from its Okta session
+			profile: "okta",
+			matches: []sessioncache.Entry{oktaByDev, devRole, prodRole, legacyOkta},
+			others:  []sessioncache.Entry{otherSession},
+		},
+		{
+			profile: "other",
+			matches: []sessioncache.Entry{otherSession},
+			others:  []sessioncache.Entry{oktaByDev, devRole, prodRole, legacyOkta},
+		},
+	} {
+		t.Run(tc.profile, func(t *testing.T) {
+			for _, entry := range tc.matches {
+				assert.True(t, profiles.SessionOfProfile(entry, tc.profile), "%s of %s", entry.Key, entry.Profile)
+			}
+			for _, entry := range tc.others {
+				assert.False(t, profiles.SessionOfProfile(entry, tc.profile), "%s of %s", entry.Key, entry.Profile)
+			}
+		})
+	}
+}
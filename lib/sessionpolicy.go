@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/mitchellh/go-homedir"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// SessionPolicy scopes down the credentials of an assumed role: they are
+// allowed what both the role and the policies allow
+type SessionPolicy struct {
+	// Policy is an inline policy document
+	Policy string
+	// ARNs are of managed policies
+	ARNs []string
+}
+
+// IsZero returns whether there is no policy, so the credentials aren't
+// scoped down
+func (s SessionPolicy) IsZero() bool {
+	return s.Policy == "" && len(s.ARNs) == 0
+}
+
+// String returns the policies, to tell sessions scoped down with different
+// policies apart
+func (s SessionPolicy) String() string {
+	if s.IsZero() {
+		return ""
+	}
+	return strings.Join(s.ARNs, ",") + "\n" + s.Policy
+}
+
+func (s SessionPolicy) policyDescriptors() []*sts.PolicyDescriptorType {
+	var descriptors []*sts.PolicyDescriptorType
+	for _, arn := range s.ARNs {
+		descriptors = append(descriptors, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	return descriptors
+}
+
+// ReadSessionPolicy returns the policy document policy, which is either the
+// JSON of the document or the path of a file holding it
+func ReadSessionPolicy(policy string) (string, error) {
+	policy = strings.TrimSpace(policy)
+	if !strings.HasPrefix(policy, "{") {
+		path, err := homedir.Expand(policy)
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", xerrors.Errorf("reading session policy: %w", err)
+		}
+		policy = strings.TrimSpace(string(data))
+	}
+	if !json.Valid([]byte(policy)) {
+		return "", xerrors.New("session policy is not valid JSON")
+	}
+	return policy, nil
+}
+
+// ParsePolicyARNs splits the comma separated policy ARNs of `policy_arns`
+func ParsePolicyARNs(arns string) []string {
+	var parsed []string
+	for _, arn := range strings.Split(arns, ",") {
+		if arn = strings.TrimSpace(arn); arn != "" {
+			parsed = append(parsed, arn)
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSessionPolicy(t *testing.T) {
+	document := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+
+	t.Run("inline", func(t *testing.T) {
+		policy, err := ReadSessionPolicy("  " + document + "\n")
+		if assert.NoError(t, err) {
+			assert.Equal(t, document, policy)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "aws-okta-policy")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "policy.json")
+		assert.NoError(t, ioutil.WriteFile(path, []byte(document+"\n"), 0600))
+
+		policy, err := ReadSessionPolicy(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, document, policy)
+		}
+
+		_, err = ReadSessionPolicy(filepath.Join(dir, "missing.json"))
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ReadSessionPolicy(`{"Version":`)
+		assert.Error(t, err)
+	})
+}
+
+func TestParsePolicyARNs(t *testing.T) {
+	assert.Nil(t, ParsePolicyARNs(""))
+	assert.Equal(t, []string{
+		"arn:aws:iam::aws:policy/ReadOnlyAccess",
+		"arn:aws:iam::123456789012:policy/deny-secrets",
+	}, ParsePolicyARNs("arn:aws:iam::aws:policy/ReadOnlyAccess, arn:aws:iam::123456789012:policy/deny-secrets,"))
+}
+
+func TestSessionPolicyString(t *testing.T) {
+	assert.Equal(t, "", SessionPolicy{}.String())
+	assert.NotEqual(t,
+		SessionPolicy{ARNs: []string{"a"}}.String(),
+		SessionPolicy{Policy: "a"}.String())
+}
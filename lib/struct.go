@@ -2,8 +2,9 @@ package lib
 
 // http://developer.okta.com/docs/api/resources/authn.html
 type OktaUser struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	StateToken string `json:"stateToken,omitempty"`
 }
 
 type OktaStateToken struct {
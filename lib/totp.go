@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// MFASecretKeyringKey returns the keyring key of the secret of the virtual
+// MFA device serial, used to compute codes for profiles with `mfa_serial`
+func MFASecretKeyringKey(serial string) string {
+	return "aws-mfa-secret-" + serial
+}
+
+// TOTPCode returns the code of the base32 encoded secret at t, as virtual
+// MFA devices compute it (RFC 6238 with HMAC-SHA1, 30 second steps and 6
+// digits)
+func TOTPCode(secret string, t time.Time) (string, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", xerrors.Errorf("decoding MFA secret: %w", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
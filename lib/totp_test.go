@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// the SHA1 test vectors of RFC 6238, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for ts, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := TOTPCode(secret, time.Unix(ts, 0))
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, code, "at %d", ts)
+		}
+	}
+
+	t.Run("lowercase with spaces", func(t *testing.T) {
+		code, err := TOTPCode("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "287082", code)
+		}
+	})
+
+	t.Run("invalid secret", func(t *testing.T) {
+		_, err := TOTPCode("not base32!", time.Unix(59, 0))
+		assert.Error(t, err)
+	})
+}
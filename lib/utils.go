@@ -1,19 +1,22 @@
 package lib
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
-	"os"
-	"sort"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/segmentio/aws-okta/lib/saml"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
 )
 
 // Role arn format => arn:${Partition}:iam::${Account}:role/${RoleNameWithPath}
@@ -33,6 +36,30 @@ func GetRoleFromSAML(resp *saml.Response, profileARN string) (string, string, er
 	return role.Principal, role.Role, nil
 }
 
+// GetSessionDurationFromSAML returns the session duration to request with
+// resp: requested, or the SessionDuration attribute's value if requested is 0
+// or longer. It returns 0 if requested is 0 and there is no attribute, to let
+// STS pick.
+func GetSessionDurationFromSAML(resp *saml.Response, requested time.Duration) time.Duration {
+	max, ok, err := resp.SessionDuration()
+	if err != nil {
+		log.Warnf("Ignoring SAML session duration: %s", err)
+	}
+	if !ok {
+		return requested
+	}
+	log.Debugf("Got SAML session duration: %s", max)
+
+	if requested == 0 {
+		return max
+	}
+	if requested > max {
+		log.Debugf("Shortening session duration %s to %s", requested, max)
+		return max
+	}
+	return requested
+}
+
 func GetAssumableRolesFromSAML(resp *saml.Response) (saml.AssumableRoles, error) {
 	roleList := []saml.AssumableRole{}
 
@@ -77,6 +104,12 @@ func GetAssumableRolesFromSAML(resp *saml.Response) (saml.AssumableRoles, error)
 }
 
 func GetRole(roleList saml.AssumableRoles, profileARN string) (saml.AssumableRole, error) {
+	return GetRoleWithAliases(roleList, profileARN, nil)
+}
+
+// GetRoleWithAliases is GetRole, naming accounts with aliases when prompting
+// for a role
+func GetRoleWithAliases(roleList saml.AssumableRoles, profileARN string, aliases AccountAliases) (saml.AssumableRole, error) {
 
 	// if the user doesn't have any roles they can assume return an error.
 	if len(roleList) == 0 {
@@ -90,7 +123,8 @@ func GetRole(roleList saml.AssumableRoles, profileARN string) (saml.AssumableRol
 				return arole, nil
 			}
 		}
-		return saml.AssumableRole{}, fmt.Errorf("ARN isn't valid")
+		// or it may be a role name or pattern
+		return findRole(roleList, profileARN, aliases)
 	}
 
 	// if the user only has one role assume that role without prompting.
@@ -98,39 +132,7 @@ func GetRole(roleList saml.AssumableRoles, profileARN string) (saml.AssumableRol
 		return roleList[0], nil
 	}
 
-	// Sort the roles in alphabetical order
-	sort.Slice(roleList, func(i, j int) bool {
-		return roleList[i].Role < roleList[j].Role
-	})
-
-	var roleName, previousAccountID, currentAccountID string
-
-	for i, arole := range roleList {
-		currentAccountID, roleName = accountIDAndRoleFromRoleARN(arole.Role)
-		if currentAccountID != previousAccountID {
-			fmt.Fprintf(os.Stderr, "\nAccount: %s\n", currentAccountID)
-		}
-		previousAccountID = currentAccountID
-
-		fmt.Fprintf(os.Stderr, "%4d - %s\n", i, roleName)
-	}
-	fmt.Fprintln(os.Stderr, "")
-
-	i, err := Prompt("Select Role to Assume", false)
-	if err != nil {
-		return saml.AssumableRole{}, err
-	}
-	if i == "" {
-		return saml.AssumableRole{}, errors.New("Invalid selection - Please use an option that is listed")
-	}
-	factorIdx, err := strconv.Atoi(i)
-	if err != nil {
-		return saml.AssumableRole{}, err
-	}
-	if factorIdx > (len(roleList) - 1) {
-		return saml.AssumableRole{}, errors.New("Invalid selection - Please use an option that is listed")
-	}
-	return roleList[factorIdx], nil
+	return PickRole(roleList, aliases, "")
 }
 
 func ParseSAML(body []byte, resp *SAMLAssertion) (err error) {
@@ -146,9 +148,7 @@ func ParseSAML(body []byte, resp *SAMLAssertion) (err error) {
 	val, _ = GetNode(doc, "SAMLResponse")
 	if val != "" {
 		resp.RawData = []byte(val)
-		val = strings.Replace(val, "&#x2b;", "+", -1)
-		val = strings.Replace(val, "&#x3d;", "=", -1)
-		data, err = base64.StdEncoding.DecodeString(val)
+		data, err = decodeSAMLResponse(resp.RawData)
 		if err != nil {
 			return
 		}
@@ -159,6 +159,69 @@ func ParseSAML(body []byte, resp *SAMLAssertion) (err error) {
 	return
 }
 
+// ReadSAMLAssertion parses a SAML response obtained outside of aws-okta, in
+// any of these forms:
+//   - the base64 encoded SAMLResponse, e.g. from `aws-okta saml --raw`
+//   - the form posted to AWS by the browser (SAMLResponse=...&RelayState=...)
+//   - the HTML page with the SAMLResponse form, as served by Okta
+//   - the decoded XML
+func ReadSAMLAssertion(data []byte) (*SAMLAssertion, error) {
+	data = bytes.TrimSpace(data)
+	var assertion SAMLAssertion
+
+	switch {
+	case bytes.HasPrefix(data, []byte("<")) && bytes.Contains(data, []byte("SAMLResponse")):
+		if err := ParseSAML(data, &assertion); err != nil {
+			return nil, err
+		}
+		if assertion.RawData == nil {
+			return nil, errors.New("no SAMLResponse found in HTML")
+		}
+		return &assertion, nil
+	case bytes.HasPrefix(data, []byte("<")):
+		assertion.RawData = []byte(base64.StdEncoding.EncodeToString(data))
+	case bytes.HasPrefix(data, []byte("SAMLResponse=")) || bytes.Contains(data, []byte("&SAMLResponse=")):
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		assertion.RawData = []byte(form.Get("SAMLResponse"))
+	default:
+		assertion.RawData = data
+	}
+
+	xmlData, err := decodeSAMLResponse(assertion.RawData)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding SAMLResponse: %w", err)
+	}
+	if err := xml.Unmarshal(xmlData, &assertion.Resp); err != nil {
+		return nil, xerrors.Errorf("parsing SAMLResponse: %w", err)
+	}
+	return &assertion, nil
+}
+
+func decodeSAMLResponse(rawData []byte) ([]byte, error) {
+	val := string(rawData)
+	val = strings.Replace(val, "&#x2b;", "+", -1)
+	val = strings.Replace(val, "&#x3d;", "=", -1)
+	return base64.StdEncoding.DecodeString(val)
+}
+
+// ValidateSAML checks resp's status, conditions and, if opts has the IdP's
+// certificates, signature. resp.Resp is replaced by the validated response.
+func ValidateSAML(resp *SAMLAssertion, opts saml.ValidationOptions) error {
+	data, err := decodeSAMLResponse(resp.RawData)
+	if err != nil {
+		return err
+	}
+	validated, err := saml.Validate(data, opts)
+	if err != nil {
+		return err
+	}
+	resp.Resp = validated
+	return nil
+}
+
 func GetNode(n *html.Node, name string) (val string, node *html.Node) {
 	var isMatch bool
 	if n.Type == html.ElementNode && n.Data == "input" {
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/segmentio/aws-okta/lib/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func samlResponseWithAttributes(t *testing.T, attributes string) *saml.Response {
+	data := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">` +
+		`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion">` +
+		`<saml2:AttributeStatement>` + attributes + `</saml2:AttributeStatement>` +
+		`</saml2:Assertion>` +
+		`</samlp:Response>`
+	var resp saml.Response
+	if err := xml.Unmarshal([]byte(data), &resp); err != nil {
+		t.Fatalf("parsing SAML: %s", err)
+	}
+	return &resp
+}
+
+func TestGetSessionDurationFromSAML(t *testing.T) {
+	withDuration := samlResponseWithAttributes(t,
+		`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/SessionDuration">`+
+			`<saml2:AttributeValue> 7200 </saml2:AttributeValue>`+
+			`</saml2:Attribute>`)
+	withoutDuration := samlResponseWithAttributes(t, "")
+	invalidDuration := samlResponseWithAttributes(t,
+		`<saml2:Attribute Name="https://aws.amazon.com/SAML/Attributes/SessionDuration">`+
+			`<saml2:AttributeValue>2h</saml2:AttributeValue>`+
+			`</saml2:Attribute>`)
+
+	for _, tc := range []struct {
+		name      string
+		resp      *saml.Response
+		requested time.Duration
+		expected  time.Duration
+	}{
+		{"attribute", withDuration, 0, 2 * time.Hour},
+		{"shorter than attribute", withDuration, time.Hour, time.Hour},
+		{"clamped to attribute", withDuration, 12 * time.Hour, 2 * time.Hour},
+		{"no attribute", withoutDuration, 0, 0},
+		{"no attribute with duration", withoutDuration, 12 * time.Hour, 12 * time.Hour},
+		{"invalid attribute", invalidDuration, time.Hour, time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, GetSessionDurationFromSAML(tc.resp, tc.requested))
+		})
+	}
+}
+
+func TestReadSAMLAssertion(t *testing.T) {
+	data := `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">` +
+		`<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion">` +
+		`<saml2:Subject><saml2:NameID>someone@example.com</saml2:NameID></saml2:Subject>` +
+		`</saml2:Assertion>` +
+		`</samlp:Response>`
+	encoded := base64.StdEncoding.EncodeToString([]byte(data))
+
+	for _, tc := range []struct {
+		name  string
+		input string
+	}{
+		{"base64", encoded + "\n"},
+		{"form", "SAMLResponse=" + url.QueryEscape(encoded) + "&RelayState="},
+		{"html", `<html><body><form><input name="SAMLResponse" type="hidden" value="` + encoded + `"/></form></body></html>`},
+		{"xml", data},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assertion, err := ReadSAMLAssertion([]byte(tc.input))
+			if assert.NoError(t, err) {
+				assert.Equal(t, encoded, string(assertion.RawData))
+				assert.Equal(t, "someone@example.com", assertion.Resp.Assertion.Subject.NameID.Value)
+			}
+		})
+	}
+
+	_, err := ReadSAMLAssertion([]byte("not a SAML response"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package sessioncache
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// AssumedRoleKey is the key of the session of a role assumed with the
+// credentials of another session, as for profiles with a source_profile
+type AssumedRoleKey struct {
+	RoleARN         string
+	RoleSessionName string
+	Duration        time.Duration
+	// ExternalID is the external ID the role is assumed with, if any
+	ExternalID string
+	// MFASerial is the MFA device the role is assumed with, if any
+	MFASerial string
+	// Policy is the session policy the session is scoped down with, if any
+	Policy string
+	// Tags are the session tags and source identity of the session, if any
+	Tags string
+}
+
+// Key returns a key for the keyring item. The fields are hashed, as keyring
+// item keys may be visible to other applications, one per line as
+// `name=value`, with the value quoted, so that a field can't spill into the
+// next.
+func (k AssumedRoleKey) Key() string {
+	hasher := md5.New()
+	fmt.Fprintf(hasher, "duration=%q\n", k.Duration.String())
+	fmt.Fprintf(hasher, "role_arn=%q\n", k.RoleARN)
+	fmt.Fprintf(hasher, "role_session_name=%q\n", k.RoleSessionName)
+	// only hashed when set, to keep the keys of sessions without them
+	if k.ExternalID != "" {
+		fmt.Fprintf(hasher, "external_id=%q\n", k.ExternalID)
+	}
+	if k.MFASerial != "" {
+		fmt.Fprintf(hasher, "mfa_serial=%q\n", k.MFASerial)
+	}
+	if k.Policy != "" {
+		fmt.Fprintf(hasher, "policy=%q\n", k.Policy)
+	}
+	if k.Tags != "" {
+		fmt.Fprintf(hasher, "tags=%q\n", k.Tags)
+	}
+
+	return fmt.Sprintf("assumed role session (%s)", hex.EncodeToString(hasher.Sum(nil))[0:10])
+}
@@ -0,0 +1,44 @@
+package sessioncache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// OktaSessionKey is the key of a session created with a SAML assertion from
+// Okta, built only from what the session depends on, so that unrelated edits
+// to the profile, such as its output format, keep its cached session
+type OktaSessionKey struct {
+	// SourceProfile is the profile whose settings authenticate with Okta; it
+	// is only used to name the key
+	SourceProfile string
+	// SAMLURL is the aws_saml_url of the Okta app
+	SAMLURL string
+	// OktaAccount is the keyring item of the Okta credentials
+	OktaAccount string
+	// RoleARN is the role assumed with the assertion, or empty if it is
+	// picked when authenticating
+	RoleARN  string
+	Duration time.Duration
+	// Policy is the session policy the session is scoped down with, if any
+	Policy string
+}
+
+// Key returns a key for the keyring item: the source profile name, and the
+// first 16 hex digits of the SHA-256 of the other fields. The fields are
+// hashed, as keyring item keys may be visible to other applications, one per
+// line as `name=value`, with the value quoted, so that a field can't spill
+// into the next. The key is marked v2, to tell it from those of
+// KeyWithProfileARN.
+func (k OktaSessionKey) Key() string {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "saml_url=%q\n", k.SAMLURL)
+	fmt.Fprintf(hasher, "okta_account=%q\n", k.OktaAccount)
+	fmt.Fprintf(hasher, "role_arn=%q\n", k.RoleARN)
+	fmt.Fprintf(hasher, "duration=%q\n", k.Duration.String())
+	fmt.Fprintf(hasher, "policy=%q\n", k.Policy)
+
+	return fmt.Sprintf("%s session v2 (%s)", k.SourceProfile, hex.EncodeToString(hasher.Sum(nil))[0:16])
+}
@@ -13,10 +13,15 @@ type KeyWithProfileARN struct {
 	ProfileConf map[string]string
 	Duration    time.Duration
 	ProfileARN  string
+	// Policy is the session policy the session is scoped down with, if any
+	Policy string
 }
 
 // Key returns a key for the keyring item. For all purposes it behaves the same way as
 // OrigKey but also takes the ProfileARN into account when generating the key value.
+//
+// Sessions are now cached at OktaSessionKey; this key only finds those cached
+// by older versions.
 func (k KeyWithProfileARN) Key() string {
 	var source string
 	if source = k.ProfileConf["source_profile"]; source == "" {
@@ -25,6 +30,10 @@ func (k KeyWithProfileARN) Key() string {
 	hasher := md5.New()
 	hasher.Write([]byte(k.Duration.String()))
 	hasher.Write([]byte(k.ProfileARN))
+	// only hashed when set, to keep the keys of unscoped sessions
+	if k.Policy != "" {
+		hasher.Write([]byte(k.Policy))
+	}
 
 	enc := json.NewEncoder(hasher)
 	enc.Encode(k.ProfileConf)
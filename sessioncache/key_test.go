@@ -0,0 +1,104 @@
+package sessioncache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	profileKey := KeyWithProfileARN{
+		ProfileName: "okta",
+		ProfileConf: map[string]string{"role_arn": "arn:aws:iam::123456789012:role/admin"},
+		Duration:    time.Hour,
+	}
+	roleKey := AssumedRoleKey{
+		RoleARN:         "arn:aws:iam::123456789012:role/admin",
+		RoleSessionName: "user",
+		Duration:        time.Hour,
+	}
+
+	// the keys of unscoped sessions don't change with the policy field
+	assert.Equal(t, "okta session (37313661633035306533)", profileKey.Key())
+	assert.Equal(t, "assumed role session (8a2dafd8a9)", roleKey.Key())
+
+	scopedProfileKey := profileKey
+	scopedProfileKey.Policy = "arn:aws:iam::aws:policy/ReadOnlyAccess\n"
+	assert.NotEqual(t, profileKey.Key(), scopedProfileKey.Key())
+
+	scopedRoleKey := roleKey
+	scopedRoleKey.Policy = "arn:aws:iam::aws:policy/ReadOnlyAccess\n"
+	assert.NotEqual(t, roleKey.Key(), scopedRoleKey.Key())
+}
+
+func TestOktaSessionKey(t *testing.T) {
+	key := OktaSessionKey{
+		SourceProfile: "okta",
+		SAMLURL:       "home/amazon_aws/0oa1/272",
+		OktaAccount:   "okta-creds",
+		RoleARN:       "arn:aws:iam::123456789012:role/admin",
+		Duration:      time.Hour,
+	}
+
+	// the key is documented, so it must not change between versions
+	assert.Equal(t, "okta session v2 (cff1a1b1cbec2692)", key.Key())
+	assert.Regexp(t, sessionItemKeyRegex, key.Key())
+
+	// each field changes the key
+	changes := []func(k *OktaSessionKey){
+		func(k *OktaSessionKey) { k.SourceProfile = "other" },
+		func(k *OktaSessionKey) { k.SAMLURL = "home/amazon_aws/0oa2/272" },
+		func(k *OktaSessionKey) { k.OktaAccount = "okta-creds-other" },
+		func(k *OktaSessionKey) { k.RoleARN = "" },
+		func(k *OktaSessionKey) { k.Duration = 2 * time.Hour },
+		func(k *OktaSessionKey) { k.Policy = "arn:aws:iam::aws:policy/ReadOnlyAccess\n" },
+	}
+	for _, change := range changes {
+		changed := key
+		change(&changed)
+		assert.NotEqual(t, key.Key(), changed.Key())
+	}
+
+	// a field can't spill into the next
+	spilled := key
+	spilled.SAMLURL, spilled.OktaAccount = key.SAMLURL+"\nokta_account=", ""
+	assert.NotEqual(t, key.Key(), spilled.Key())
+}
+
+func TestAssumedRoleKey(t *testing.T) {
+	key := AssumedRoleKey{
+		RoleARN:         "arn:aws:iam::123456789012:role/admin",
+		RoleSessionName: "user",
+		Duration:        time.Hour,
+	}
+	assert.Regexp(t, sessionItemKeyRegex, key.Key())
+
+	// each field changes the key
+	changes := []func(k *AssumedRoleKey){
+		func(k *AssumedRoleKey) { k.RoleARN = "arn:aws:iam::123456789012:role/other" },
+		func(k *AssumedRoleKey) { k.RoleSessionName = "other" },
+		func(k *AssumedRoleKey) { k.Duration = 2 * time.Hour },
+		func(k *AssumedRoleKey) { k.ExternalID = "external-id" },
+		func(k *AssumedRoleKey) { k.MFASerial = "arn:aws:iam::123456789012:mfa/user" },
+		func(k *AssumedRoleKey) { k.Policy = "arn:aws:iam::aws:policy/ReadOnlyAccess\n" },
+		func(k *AssumedRoleKey) { k.Tags = "OktaUser=user" },
+	}
+	for _, change := range changes {
+		changed := key
+		change(&changed)
+		assert.NotEqual(t, key.Key(), changed.Key())
+	}
+
+	// a field can't spill into the next
+	spilled := key
+	spilled.ExternalID, spilled.MFASerial = "id", "serial"
+	other := key
+	other.ExternalID = "id\nmfa_serial=\"serial\""
+	assert.NotEqual(t, spilled.Key(), other.Key())
+	spilled = key
+	spilled.Policy, spilled.Tags = "policy", "tags"
+	other = key
+	other.Policy = "policytags"
+	assert.NotEqual(t, spilled.Key(), other.Key())
+}
@@ -0,0 +1,82 @@
+package sessioncache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// DefaultLockTimeout is how long to wait for a lock held by another process
+const DefaultLockTimeout = 10 * time.Second
+
+// lockRetryInterval is how often a held lock is tried again
+const lockRetryInterval = 10 * time.Millisecond
+
+// ErrLockTimeout is returned when a lock is still held by another process
+// after the timeout
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// FileLock is an exclusive lock on a file, held across processes. The lock is
+// released when the process exits, so a crashed process can't keep it.
+type FileLock struct {
+	f *os.File
+}
+
+// LockFile locks the file at path, creating it if needed, waiting up to
+// timeout for other processes to release it
+func LockFile(path string, timeout time.Duration) (*FileLock, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, xerrors.Errorf("locking %s: %w", path, err)
+		}
+		if locked {
+			return &FileLock{f: f}, nil
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, xerrors.Errorf("locking %s: %w", path, ErrLockTimeout)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// unlockOrLog releases the lock, if any, logging failures, for deferring
+func (l *FileLock) unlockOrLog() {
+	if l == nil {
+		return
+	}
+	if err := l.Unlock(); err != nil {
+		log.Debugf("cache: unlocking: %s", err)
+	}
+}
@@ -0,0 +1,42 @@
+package sessioncache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+)
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "test.lock")
+
+	lock, err := LockFile(path, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = LockFile(path, 50*time.Millisecond)
+	assert.True(t, xerrors.Is(err, ErrLockTimeout), "got %v", err)
+
+	// a waiter gets the lock once it is released
+	released := make(chan error)
+	go func() {
+		waiter, err := LockFile(path, 5*time.Second)
+		if err == nil {
+			err = waiter.Unlock()
+		}
+		released <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, lock.Unlock())
+	assert.NoError(t, <-released)
+}
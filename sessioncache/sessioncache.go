@@ -9,11 +9,24 @@ import (
 	"errors"
 
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/segmentio/aws-okta/lib/saml"
 )
 
 // Session adds a session name to sts.Credentials
 type Session struct {
 	Name string
+	// Attributes are those of the SAML assertion the session was created with
+	Attributes *saml.SessionAttributes `json:",omitempty"`
+	// Username is the Okta username the session was created for
+	Username string `json:",omitempty"`
+	// Profile is the profile the session was created for
+	Profile string `json:",omitempty"`
+	// SourceProfile is the source profile of Profile, whose Okta session the
+	// session is, or was assumed with
+	SourceProfile string `json:",omitempty"`
+	// SAMLAssertion, if set, makes this entry a cached SAML assertion rather
+	// than a session; it expires with the assertion
+	SAMLAssertion []byte `json:",omitempty"`
 	sts.Credentials
 }
 
@@ -26,4 +39,17 @@ type Key interface {
 	Key() string
 }
 
+// RawKey is a key as returned by Key(), such as those of listed entries
+type RawKey string
+
+func (k RawKey) Key() string {
+	return string(k)
+}
+
+// Entry is a cached session and its key, as listed by stores
+type Entry struct {
+	Key string
+	Session
+}
+
 var ErrSessionExpired = errors.New("session expired")
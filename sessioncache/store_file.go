@@ -0,0 +1,413 @@
+package sessioncache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
+)
+
+// DefaultFileStorePath is where FileStore keeps sessions by default
+const DefaultFileStorePath = "~/.aws-okta/session-cache.enc"
+
+// FileStoreKeyItemKey is the keyring item holding the key of the FileStore
+// file, when it isn't encrypted with a passphrase
+const FileStoreKeyItemKey = "session-cache-file-key"
+
+// ErrFileStoreKeyMismatch is returned when the file can't be decrypted, as
+// when the passphrase changed
+var ErrFileStoreKeyMismatch = errors.New("session cache file was encrypted with another key")
+
+// ErrFileStoreNeedsPassphrase is returned when there is no passphrase, and no
+// keyring that can keep the file's key without prompting
+var ErrFileStoreNeedsPassphrase = errors.New("session cache file needs a passphrase without a system keyring")
+
+// the file is JSON, the sessions encrypted with AES-256-GCM
+type fileStoreEnvelope struct {
+	// Salt of the passphrase's key, or empty if the key is in the keyring
+	Salt  []byte `json:",omitempty"`
+	Nonce []byte
+	Data  []byte
+}
+
+type fileStoreDb struct {
+	Sessions map[string]Session
+}
+
+// FileStore stores all sessions in an encrypted file
+//
+// This is for machines without a usable keyring, such as headless Linux boxes
+// and containers, where reading the file backend's items prompts for its
+// passphrase every time. The file is encrypted with a key derived from
+// Passphrase if it is set, or else a random key kept in Keyring, which is then
+// only read once per run.
+//
+// Writes read, modify and replace the whole file, so they are serialized
+// with a lock file; reads don't need it, as the file is replaced atomically.
+type FileStore struct {
+	// Path of the file; DefaultFileStorePath if empty
+	Path       string
+	Passphrase string
+	Keyring    keyring.Keyring
+	// KeyringPrompts is set when reading Keyring prompts for a passphrase, as
+	// the file backend does; the file's key isn't kept in it then, and
+	// Passphrase is needed
+	KeyringPrompts bool
+	// LockPath is the lock file; Path with ".lock" appended if empty
+	LockPath string
+	// LockTimeout is how long to wait for the lock; DefaultLockTimeout if
+	// zero. If it times out, writes return wrapped ErrLockTimeout.
+	LockTimeout time.Duration
+
+	// the key is cached, as deriving it from the passphrase is slow on purpose
+	key  []byte
+	salt []byte
+}
+
+func (s *FileStore) path() (string, error) {
+	path := s.Path
+	if path == "" {
+		path = DefaultFileStorePath
+	}
+	return homedir.Expand(path)
+}
+
+// lock takes the store's lock
+func (s *FileStore) lock() (*FileLock, error) {
+	path := s.LockPath
+	if path == "" {
+		filePath, err := s.path()
+		if err != nil {
+			return nil, err
+		}
+		path = filePath + ".lock"
+	}
+	timeout := s.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	return LockFile(path, timeout)
+}
+
+// getKey returns the key of the file, deriving it from the passphrase with
+// salt. If the key is in the keyring and create is true, it is created if it
+// doesn't exist yet.
+func (s *FileStore) getKey(salt []byte, create bool) ([]byte, error) {
+	if s.Passphrase != "" {
+		if s.key != nil && string(s.salt) == string(salt) {
+			return s.key, nil
+		}
+		key, err := scrypt.Key([]byte(s.Passphrase), salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return nil, err
+		}
+		s.key, s.salt = key, salt
+		return key, nil
+	}
+
+	if s.key != nil {
+		return s.key, nil
+	}
+	if s.Keyring == nil || s.KeyringPrompts {
+		return nil, ErrFileStoreNeedsPassphrase
+	}
+	item, err := s.Keyring.Get(FileStoreKeyItemKey)
+	if err == nil {
+		s.key = item.Data
+		return s.key, nil
+	} else if err != keyring.ErrKeyNotFound {
+		return nil, xerrors.Errorf("failed Keyring.Get(%q): %w", FileStoreKeyItemKey, err)
+	} else if !create {
+		// the file can't be decrypted without its key
+		return nil, ErrFileStoreKeyMismatch
+	}
+
+	log.Debugf("cache: creating session cache file key")
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := s.Keyring.Set(keyring.Item{
+		Key:                         FileStoreKeyItemKey,
+		Label:                       "aws-okta session cache file key",
+		Data:                        key,
+		KeychainNotTrustApplication: false,
+	}); err != nil {
+		return nil, xerrors.Errorf("writing %q: %w", FileStoreKeyItemKey, err)
+	}
+	s.key = key
+	return key, nil
+}
+
+// getDb reads and decrypts the file
+//
+// if the file doesn't exist, returns wrapped keyring.ErrKeyNotFound, so that
+// a missing file is a cache miss as for the keyring stores
+func (s *FileStore) getDb() (*fileStoreDb, error) {
+	path, err := s.path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, xerrors.Errorf("reading %s: %w", path, keyring.ErrKeyNotFound)
+	} else if err != nil {
+		return nil, xerrors.Errorf("reading %s: %w", path, err)
+	}
+
+	var envelope fileStoreEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, xerrors.Errorf("failed unmarshal for %s: %w", path, err)
+	}
+	if (s.Passphrase != "") != (len(envelope.Salt) > 0) {
+		return nil, ErrFileStoreKeyMismatch
+	}
+	key, err := s.getKey(envelope.Salt, false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newFileStoreAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Data, nil)
+	if err != nil {
+		return nil, ErrFileStoreKeyMismatch
+	}
+
+	var db fileStoreDb
+	if err := json.Unmarshal(plaintext, &db); err != nil {
+		return nil, xerrors.Errorf("failed unmarshal for %s: %w", path, err)
+	}
+	return &db, nil
+}
+
+// putDb encrypts db and replaces the file with it
+func (s *FileStore) putDb(db *fileStoreDb) error {
+	path, err := s.path()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(db)
+	if err != nil {
+		return err
+	}
+
+	var envelope fileStoreEnvelope
+	if s.Passphrase != "" {
+		// keep the salt, so that the derived key can be reused
+		envelope.Salt = s.salt
+		if envelope.Salt == nil {
+			envelope.Salt = make([]byte, 16)
+			if _, err := io.ReadFull(rand.Reader, envelope.Salt); err != nil {
+				return err
+			}
+		}
+	}
+	key, err := s.getKey(envelope.Salt, true)
+	if err != nil {
+		return err
+	}
+	aead, err := newFileStoreAEAD(key)
+	if err != nil {
+		return err
+	}
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, envelope.Nonce); err != nil {
+		return err
+	}
+	envelope.Data = aead.Seal(nil, envelope.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func newFileStoreAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic replaces the file at path with data, by renaming a
+// temporary file over it, so that readers never see a partial file. The file
+// is only readable by the user.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// TempFile creates the file with mode 0600
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get decrypts the file, and returns the session at k.Key()
+//
+// If the file doesn't exist or the key is not found, returns wrapped
+// keyring.ErrKeyNotFound
+//
+// If the session is found, but is expired, returns wrapped ErrSessionExpired
+func (s *FileStore) Get(k Key) (*Session, error) {
+	keyStr := k.Key()
+
+	currentDb, err := s.getDb()
+	if err != nil {
+		log.Debugf("cache get `%s`: miss (read error): %s", keyStr, err)
+		return nil, xerrors.Errorf("failed loading db for %q: %w", keyStr, err)
+	}
+
+	session, ok := currentDb.Sessions[keyStr]
+	if !ok {
+		log.Debugf("cache get `%s`: miss", keyStr)
+		return nil, xerrors.Errorf("failed finding session for %q: %w", keyStr, keyring.ErrKeyNotFound)
+	}
+
+	if session.Expiration.Before(time.Now()) {
+		log.Debugf("cache get `%s`: expired", keyStr)
+		return nil, xerrors.Errorf("session expired for %q: %w", keyStr, ErrSessionExpired)
+	}
+
+	log.Debugf("cache get `%s`: hit", keyStr)
+	return &session, nil
+}
+
+// Put adds session to the file at k.Key()
+//
+// If the file can't be decrypted, as after the passphrase changed, returns
+// ErrFileStoreKeyMismatch and leaves the file alone; Clear removes it. If the
+// lock times out, returns wrapped ErrLockTimeout.
+func (s *FileStore) Put(k Key, session *Session) error {
+	keyStr := k.Key()
+
+	l, err := s.lock()
+	if err != nil {
+		log.Debugf("cache put `%s`: error (locking): %s", keyStr, err)
+		return xerrors.Errorf("locking db for %q: %w", keyStr, err)
+	}
+	defer l.unlockOrLog()
+
+	currentDb, err := s.getDb()
+	if xerrors.Is(err, keyring.ErrKeyNotFound) || (currentDb != nil && currentDb.Sessions == nil) {
+		log.Debugf("cache put: new db")
+		currentDb = &fileStoreDb{
+			Sessions: map[string]Session{},
+		}
+	} else if err != nil {
+		log.Debugf("cache put `%s`: error (reading): %s", keyStr, err)
+		return xerrors.Errorf("loading db for %q: %w", keyStr, err)
+	}
+
+	currentDb.Sessions[keyStr] = *session
+
+	if err := s.putDb(currentDb); err != nil {
+		log.Debugf("cache put `%s`: error (writing): %s", keyStr, err)
+		return xerrors.Errorf("writing db for %q: %w", keyStr, err)
+	}
+	log.Debugf("cache put `%s`: success", keyStr)
+
+	return nil
+}
+
+// List returns the sessions in the file, including expired ones
+func (s *FileStore) List() ([]Entry, error) {
+	currentDb, err := s.getDb()
+	if xerrors.Is(err, keyring.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, xerrors.Errorf("loading db: %w", err)
+	}
+	return sortedEntries(currentDb.Sessions), nil
+}
+
+// Delete removes the session at k.Key() from the file
+//
+// If the file doesn't exist or the key is not found, returns wrapped
+// keyring.ErrKeyNotFound, and if the lock times out, wrapped ErrLockTimeout
+func (s *FileStore) Delete(k Key) error {
+	keyStr := k.Key()
+
+	l, err := s.lock()
+	if err != nil {
+		log.Debugf("cache delete `%s`: error (locking): %s", keyStr, err)
+		return xerrors.Errorf("locking db for %q: %w", keyStr, err)
+	}
+	defer l.unlockOrLog()
+
+	currentDb, err := s.getDb()
+	if err != nil {
+		log.Debugf("cache delete `%s`: error (reading): %s", keyStr, err)
+		return xerrors.Errorf("loading db for %q: %w", keyStr, err)
+	}
+	if _, ok := currentDb.Sessions[keyStr]; !ok {
+		return xerrors.Errorf("failed finding session for %q: %w", keyStr, keyring.ErrKeyNotFound)
+	}
+	delete(currentDb.Sessions, keyStr)
+
+	if err := s.putDb(currentDb); err != nil {
+		log.Debugf("cache delete `%s`: error (writing): %s", keyStr, err)
+		return xerrors.Errorf("writing db for %q: %w", keyStr, err)
+	}
+	log.Debugf("cache delete `%s`: success", keyStr)
+	return nil
+}
+
+// Clear removes the file, with all its sessions, even if it can't be
+// decrypted
+//
+// If the file doesn't exist, returns wrapped keyring.ErrKeyNotFound, and if
+// the lock times out, wrapped ErrLockTimeout
+func (s *FileStore) Clear() error {
+	path, err := s.path()
+	if err != nil {
+		return err
+	}
+
+	l, err := s.lock()
+	if err != nil {
+		return xerrors.Errorf("locking %s: %w", path, err)
+	}
+	defer l.unlockOrLog()
+
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return xerrors.Errorf("removing %s: %w", path, keyring.ErrKeyNotFound)
+	} else if err != nil {
+		return xerrors.Errorf("removing %s: %w", path, err)
+	}
+	log.Debugf("cache clear: removed %s", path)
+	return nil
+}
@@ -0,0 +1,127 @@
+package sessioncache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/xerrors"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("keyring key", func(t *testing.T) {
+		testStore(t, func() store {
+			f, _ := ioutil.TempFile(dir, "store")
+			f.Close()
+			os.Remove(f.Name())
+			return &FileStore{
+				Path:    f.Name(),
+				Keyring: keyring.NewArrayKeyring([]keyring.Item{}),
+			}
+		})
+	})
+
+	t.Run("passphrase", func(t *testing.T) {
+		testStore(t, func() store {
+			f, _ := ioutil.TempFile(dir, "store")
+			f.Close()
+			os.Remove(f.Name())
+			return &FileStore{
+				Path:       f.Name(),
+				Passphrase: "correct horse battery staple",
+			}
+		})
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(dir, "sub", "session-cache.enc")
+		st := &FileStore{Path: path, Passphrase: "correct horse battery staple"}
+		key := fixedKey{"file"}
+		sess := Session{
+			Name: "file",
+			Credentials: sts.Credentials{
+				Expiration: &theDistantFuture,
+			},
+		}
+		if err := st.Put(&key, &sess); err != nil {
+			t.Fatalf("error on put: %s", err)
+		}
+
+		info, err := os.Stat(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+		data, _ := ioutil.ReadFile(path)
+		assert.NotContains(t, string(data), "file")
+
+		// another passphrase can't read it, nor overwrite it
+		other := &FileStore{Path: path, Passphrase: "another"}
+		_, err = other.Get(&key)
+		assert.True(t, xerrors.Is(err, ErrFileStoreKeyMismatch), "got %v", err)
+		err = other.Put(&fixedKey{"other"}, &sess)
+		assert.True(t, xerrors.Is(err, ErrFileStoreKeyMismatch), "got %v", err)
+		_, err = st.Get(&key)
+		assert.NoError(t, err)
+
+		// no temporary files are left behind
+		files, _ := ioutil.ReadDir(filepath.Dir(path))
+		for _, file := range files {
+			assert.NotRegexp(t, `^\.`, file.Name())
+		}
+
+		// but it can be cleared
+		assert.NoError(t, other.Clear())
+		err = other.Clear()
+		assert.True(t, xerrors.Is(err, keyring.ErrKeyNotFound), "got %v", err)
+		assert.NoError(t, other.Put(&fixedKey{"other"}, &sess))
+	})
+
+	t.Run("prompting keyring", func(t *testing.T) {
+		st := &FileStore{
+			Path:           filepath.Join(dir, "prompting.enc"),
+			Keyring:        keyring.NewArrayKeyring([]keyring.Item{}),
+			KeyringPrompts: true,
+		}
+		err := st.Put(&fixedKey{"prompting"}, &Session{})
+		assert.True(t, xerrors.Is(err, ErrFileStoreNeedsPassphrase), "got %v", err)
+	})
+
+	t.Run("concurrent puts", func(t *testing.T) {
+		path := filepath.Join(dir, "concurrent.enc")
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				// as if each was another process
+				st := &FileStore{Path: path, Passphrase: "correct horse battery staple"}
+				for i := 0; i < 5; i++ {
+					key := fixedKey{fmt.Sprintf("%d-%d", g, i)}
+					sess := Session{
+						Name:        key.v,
+						Credentials: sts.Credentials{Expiration: &theDistantFuture},
+					}
+					assert.NoError(t, st.Put(&key, &sess))
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		st := &FileStore{Path: path, Passphrase: "correct horse battery staple"}
+		entries, err := st.List()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 20)
+	})
+}
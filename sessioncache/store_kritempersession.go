@@ -2,6 +2,8 @@ package sessioncache
 
 import (
 	"encoding/json"
+	"regexp"
+	"sort"
 	"time"
 
 	"github.com/99designs/keyring"
@@ -20,6 +22,11 @@ type KrItemPerSessionStore struct {
 	Keyring keyring.Keyring
 }
 
+// the keys of session items, as made by OrigKey, KeyWithProfileARN,
+// OktaSessionKey and AssumedRoleKey, and of the SAML assertions cached by lib,
+// which tell them apart from the keyring's other items
+var sessionItemKeyRegex = regexp.MustCompile(` session (v2 )?\([0-9a-f]+\)$|^okta-saml-assertion-[0-9a-f]+$`)
+
 // Get returns the session from the keyring at k.Key()
 //
 // If the keyring item is not found, returns wrapped keyring.ErrKeyNotFound
@@ -71,3 +78,49 @@ func (s *KrItemPerSessionStore) Put(k Key, session *Session) error {
 
 	return nil
 }
+
+// List returns the sessions in the keyring, including expired ones
+func (s *KrItemPerSessionStore) List() ([]Entry, error) {
+	keys, err := s.Keyring.Keys()
+	if err != nil {
+		return nil, xerrors.Errorf("failed Keyring.Keys(): %w", err)
+	}
+	sort.Strings(keys)
+
+	var entries []Entry
+	for _, key := range keys {
+		if !sessionItemKeyRegex.MatchString(key) {
+			continue
+		}
+		item, err := s.Keyring.Get(key)
+		if err != nil {
+			log.Debugf("cache list `%s`: skipped (read error): %s", key, err)
+			continue
+		}
+		var session Session
+		if err := json.Unmarshal(item.Data, &session); err != nil {
+			log.Debugf("cache list `%s`: skipped (unmarshal error): %s", key, err)
+			continue
+		}
+		entries = append(entries, Entry{Key: key, Session: session})
+	}
+	return entries, nil
+}
+
+// Delete removes the session at k.Key() from the keyring
+//
+// If the keyring item is not found, returns wrapped keyring.ErrKeyNotFound
+func (s *KrItemPerSessionStore) Delete(k Key) error {
+	keyStr := k.Key()
+	// not all backends fail to remove missing items
+	if _, err := s.Keyring.Get(keyStr); err != nil {
+		log.Debugf("cache delete `%s`: error (reading): %s", keyStr, err)
+		return xerrors.Errorf("failed Keyring.Get(%q): %w", keyStr, err)
+	}
+	if err := s.Keyring.Remove(keyStr); err != nil {
+		log.Debugf("cache delete `%s`: error: %s", keyStr, err)
+		return xerrors.Errorf("failed Keyring.Remove(%q): %w", keyStr, err)
+	}
+	log.Debugf("cache delete `%s`: success", keyStr)
+	return nil
+}
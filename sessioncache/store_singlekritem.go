@@ -2,6 +2,8 @@ package sessioncache
 
 import (
 	"encoding/json"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/99designs/keyring"
@@ -15,8 +17,99 @@ import (
 const KeyringItemKey = "session-cache"
 const KeyringItemLabel = "aws-okta session cache"
 
+// DefaultSingleKrItemLockPath is the lock file of SingleKrItemStore
+const DefaultSingleKrItemLockPath = "~/.aws-okta/session-cache.lock"
+
+// singleKrItemDbVersion is the version of the db's format. When the format
+// changes, bump it and add a migration to singleKrItemMigrations.
+const singleKrItemDbVersion = 1
+
+// ErrDbTooNew is returned when the db was written by a newer version of
+// aws-okta, whose format this one doesn't know
+var ErrDbTooNew = errors.New("session cache was written by a newer aws-okta")
+
+// ErrWriteConflict is returned when the db kept being written by another
+// writer while it was updated
+var ErrWriteConflict = errors.New("session cache kept changing while it was written")
+
+// writeAttempts is how many times the db is read, updated and written when
+// another writer keeps changing it
+const writeAttempts = 3
+
 type singleKrItemDb struct {
-	Sessions map[string]Session
+	// Version is the format of the db; dbs written before it was added are
+	// version 0
+	Version int
+	// Generation is incremented by every write, to detect writers that don't
+	// take the lock
+	Generation int64 `json:",omitempty"`
+	Sessions   map[string]Session
+}
+
+// singleKrItemMigrations[v] migrates a db from version v to v+1. They work
+// on the JSON of the db, so that they don't depend on the current types.
+var singleKrItemMigrations = []func(db map[string]interface{}) error{
+	// 0: the version and generation weren't written yet, and missing
+	// generations already read as 0
+	func(db map[string]interface{}) error {
+		return nil
+	},
+}
+
+// migrateSingleKrItemDb returns data, a db of any version, in the current
+// format
+func migrateSingleKrItemDb(data []byte) ([]byte, error) {
+	var db map[string]interface{}
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, err
+	}
+	version := 0
+	if v, ok := db["Version"].(float64); ok {
+		version = int(v)
+	}
+	if version == singleKrItemDbVersion {
+		return data, nil
+	} else if version > singleKrItemDbVersion {
+		return nil, xerrors.Errorf("version %d: %w", version, ErrDbTooNew)
+	}
+
+	for ; version < singleKrItemDbVersion; version++ {
+		log.Debugf("cache: migrating db from version %d", version)
+		if err := singleKrItemMigrations[version](db); err != nil {
+			return nil, xerrors.Errorf("migrating db from version %d: %w", version, err)
+		}
+		db["Version"] = version + 1
+	}
+	return json.Marshal(db)
+}
+
+// pruneSessions removes the expired sessions but keep, and then, if max isn't
+// 0, those expiring soonest until there are at most max
+func pruneSessions(sessions map[string]Session, keep string, max int) {
+	now := time.Now()
+	var live []string
+	for key, session := range sessions {
+		if key == keep {
+			continue
+		}
+		if session.Expiration == nil || session.Expiration.Before(now) {
+			log.Debugf("cache: pruning expired `%s`", key)
+			delete(sessions, key)
+			continue
+		}
+		live = append(live, key)
+	}
+
+	if max <= 0 || len(sessions) <= max {
+		return
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return sessions[live[i]].Expiration.Before(*sessions[live[j]].Expiration)
+	})
+	for _, key := range live[:len(sessions)-max] {
+		log.Debugf("cache: pruning `%s` to keep %d sessions", key, max)
+		delete(sessions, key)
+	}
 }
 
 // SingleKrItemStore stores all sessions in a single keyring item
@@ -24,13 +117,58 @@ type singleKrItemDb struct {
 // This is mostly for MacOS keychain, where because we don't sign aws-okta properly, the
 // user needs to reauth the aws-okta binary for every item on every upgrade. By collapsing
 // all sessions into a single item, we only need to reauth once per upgrade/build
+//
+// Since Put reads, modifies and writes the item, concurrent runs of aws-okta
+// (say, an IDE, a terminal and Terraform all running cred-process) would lose
+// each other's sessions. Access to the item is serialized with a lock file;
+// writes fail rather than go on without it.
 type SingleKrItemStore struct {
 	Keyring keyring.Keyring
+	// LockPath is the lock file; DefaultSingleKrItemLockPath if empty
+	LockPath string
+	// LockTimeout is how long to wait for the lock; DefaultLockTimeout if
+	// zero. If it times out, reads go on without it, and writes return
+	// wrapped ErrLockTimeout.
+	LockTimeout time.Duration
+	// MaxSessions, if set, caps the number of sessions kept. Put removes
+	// expired sessions, and then those expiring soonest.
+	MaxSessions int
+}
+
+// lock takes the store's lock
+//
+// Reads and writes don't handle a timeout alike: a read goes on unlocked (see
+// readLock), as the worst it can get is a stale or missing session, while a
+// write fails, as it could drop another writer's sessions.
+func (s *SingleKrItemStore) lock() (*FileLock, error) {
+	path := s.LockPath
+	if path == "" {
+		path = DefaultSingleKrItemLockPath
+	}
+	timeout := s.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	return LockFile(path, timeout)
+}
+
+// readLock takes the store's lock for reading, or returns nil if it can't, as
+// a read had better go on unlocked than fail
+func (s *SingleKrItemStore) readLock() *FileLock {
+	l, err := s.lock()
+	if err != nil {
+		log.Debugf("cache: reading without lock: %s", err)
+		return nil
+	}
+	return l
 }
 
-// getDb gets our item from the keyring and unmarshals it
+// getDb gets our item from the keyring, migrates it to the current version
+// and unmarshals it
 //
 // if the keyring item is not found, returns wrapped keyring.ErrKeyNotFound
+//
+// if the item was written by a newer aws-okta, returns wrapped ErrDbTooNew
 func (s *SingleKrItemStore) getDb() (*singleKrItemDb, error) {
 	item, err := s.Keyring.Get(KeyringItemKey)
 
@@ -38,23 +176,48 @@ func (s *SingleKrItemStore) getDb() (*singleKrItemDb, error) {
 		return nil, xerrors.Errorf("failed Keyring.Get(%q): %w", KeyringItemKey, err)
 	}
 
+	data, err := migrateSingleKrItemDb(item.Data)
+	if err != nil {
+		return nil, xerrors.Errorf("failed reading %q: %w", KeyringItemKey, err)
+	}
+
 	var unmarshalled singleKrItemDb
-	if err := json.Unmarshal(item.Data, &unmarshalled); err != nil {
+	if err := json.Unmarshal(data, &unmarshalled); err != nil {
 		return nil, xerrors.Errorf("failed unmarshal for %q: %w", KeyringItemKey, err)
 	}
 
 	return &unmarshalled, nil
 }
 
+// putDb writes db to the keyring, in the current version
+func (s *SingleKrItemStore) putDb(db *singleKrItemDb) error {
+	db.Version = singleKrItemDbVersion
+	bytes, err := json.Marshal(*db)
+	if err != nil {
+		return xerrors.Errorf("marshalling db: %w", err)
+	}
+
+	item := keyring.Item{
+		Key:                         KeyringItemKey,
+		Label:                       KeyringItemLabel,
+		Data:                        bytes,
+		KeychainNotTrustApplication: false,
+	}
+	return s.Keyring.Set(item)
+}
+
 // Get loads the db from the keyring, and returns the session at k.Key()
 //
 // If the keyring item is not found (the db hasn't been written) or the key is
 // not found, returns wrapped keyring.ErrKeyNotFound
 //
 // If the session is found, but is expired, returns wrapped ErrSessionExpired
+// (or, if the db was written by a newer aws-okta, wrapped ErrDbTooNew)
 func (s *SingleKrItemStore) Get(k Key) (*Session, error) {
 	keyStr := k.Key()
 
+	// some backends don't write items atomically
+	defer s.readLock().unlockOrLog()
 	currentDb, err := s.getDb()
 	if err != nil {
 		log.Debugf("cache get `%s`: miss (read error): %s", keyStr, err)
@@ -76,43 +239,131 @@ func (s *SingleKrItemStore) Get(k Key) (*Session, error) {
 	return &session, nil
 }
 
+// Put adds session to the db at k.Key(), and prunes the db's other sessions
+// that are expired or over MaxSessions
+//
+// The db is read, modified and written under the lock. If the lock times out,
+// returns wrapped ErrLockTimeout rather than risk losing another writer's
+// sessions.
+//
+// A db written by a newer aws-okta is left alone, and wrapped ErrDbTooNew is
+// returned, as this version would downgrade it.
 func (s *SingleKrItemStore) Put(k Key, session *Session) error {
 	keyStr := k.Key()
 
+	l, err := s.lock()
+	if err != nil {
+		log.Debugf("cache put `%s`: error (locking): %s", keyStr, err)
+		return xerrors.Errorf("locking db for %q: %w", keyStr, err)
+	}
+	defer l.unlockOrLog()
+
+	err = s.update(func(db *singleKrItemDb) error {
+		db.Sessions[keyStr] = *session
+		pruneSessions(db.Sessions, keyStr, s.MaxSessions)
+		return nil
+	})
+	if err != nil {
+		log.Debugf("cache put `%s`: error: %s", keyStr, err)
+		return xerrors.Errorf("putting %q: %w", keyStr, err)
+	}
+	log.Debugf("cache put `%s`: success", keyStr)
+
+	return nil
+}
+
+// List returns the sessions in the db, including expired ones
+func (s *SingleKrItemStore) List() ([]Entry, error) {
+	defer s.readLock().unlockOrLog()
 	currentDb, err := s.getDb()
-	if xerrors.Is(err, keyring.ErrKeyNotFound) || (currentDb != nil && currentDb.Sessions == nil) {
-		log.Debugf("cache put: new db")
-		currentDb = &singleKrItemDb{
-			Sessions: map[string]Session{},
-		}
+	if xerrors.Is(err, keyring.ErrKeyNotFound) {
+		return nil, nil
 	} else if err != nil {
-		log.Debugf("cache put `%s`: error (reading): %s", keyStr, err)
-		return xerrors.Errorf("loading db for %q: %w", keyStr, err)
+		return nil, xerrors.Errorf("loading db: %w", err)
 	}
+	return sortedEntries(currentDb.Sessions), nil
+}
 
-	currentDb.Sessions[keyStr] = *session
+// Delete removes the session at k.Key() from the db
+//
+// If the key is not found, returns wrapped keyring.ErrKeyNotFound, and if the
+// lock times out, wrapped ErrLockTimeout
+func (s *SingleKrItemStore) Delete(k Key) error {
+	keyStr := k.Key()
 
-	bytes, err := json.Marshal(*currentDb)
+	l, err := s.lock()
 	if err != nil {
-		log.Debugf("cache put `%s`: error (marshalling): %s", keyStr, err)
-		return xerrors.Errorf("marshalling db for %q: %w", keyStr, err)
+		log.Debugf("cache delete `%s`: error (locking): %s", keyStr, err)
+		return xerrors.Errorf("locking db for %q: %w", keyStr, err)
 	}
+	defer l.unlockOrLog()
 
-	// TODO: check that the db hasn't changed behind our backs
-	// Unfortunately, it seems like keyring (and MacOS keychain in general)
-	// offer no "check and set" operation that would guarantee this would work;
-	// at best, we could only make the window smaller :/
-	item := keyring.Item{
-		Key:                         KeyringItemKey,
-		Label:                       KeyringItemLabel,
-		Data:                        bytes,
-		KeychainNotTrustApplication: false,
+	err = s.update(func(db *singleKrItemDb) error {
+		if _, ok := db.Sessions[keyStr]; !ok {
+			return xerrors.Errorf("failed finding session for %q: %w", keyStr, keyring.ErrKeyNotFound)
+		}
+		delete(db.Sessions, keyStr)
+		return nil
+	})
+	if err != nil {
+		log.Debugf("cache delete `%s`: error: %s", keyStr, err)
+		return err
 	}
-	if err := s.Keyring.Set(item); err != nil {
-		log.Debugf("cache put `%s`: error (writing): %s", keyStr, err)
-		return xerrors.Errorf("writing db for %q: %w", keyStr, err)
+	log.Debugf("cache delete `%s`: success", keyStr)
+	return nil
+}
+
+// update reads the db, or starts a new one if there is none, applies change
+// to it and writes it back, with the lock held
+//
+// Writers that don't take the lock, such as older versions of aws-okta or
+// processes on another host sharing the keyring, may still write the db in
+// the meantime. So the db is read again before writing, and if its generation
+// changed, change is applied to the new db, up to writeAttempts times before
+// giving up with wrapped ErrWriteConflict.
+func (s *SingleKrItemStore) update(change func(db *singleKrItemDb) error) error {
+	for attempt := 1; ; attempt++ {
+		currentDb, err := s.getDb()
+		if xerrors.Is(err, keyring.ErrKeyNotFound) || (currentDb != nil && currentDb.Sessions == nil) {
+			log.Debugf("cache: new db")
+			currentDb = &singleKrItemDb{
+				Sessions: map[string]Session{},
+			}
+		} else if err != nil {
+			return xerrors.Errorf("loading db: %w", err)
+		}
+
+		generation := currentDb.Generation
+		if err := change(currentDb); err != nil {
+			return err
+		}
+		currentDb.Generation++
+
+		latestDb, err := s.getDb()
+		if err == nil && latestDb.Generation != generation {
+			if attempt == writeAttempts {
+				return xerrors.Errorf("generation %d, expected %d: %w", latestDb.Generation, generation, ErrWriteConflict)
+			}
+			log.Debugf("cache: conflict (generation %d, expected %d), retrying", latestDb.Generation, generation)
+			continue
+		}
+
+		if err := s.putDb(currentDb); err != nil {
+			return xerrors.Errorf("writing db: %w", err)
+		}
+		log.Debugf("cache: wrote generation %d", currentDb.Generation)
+		return nil
 	}
-	log.Debugf("cache put `%s`: success", keyStr)
+}
 
-	return nil
+// sortedEntries returns the entries of sessions, sorted by key
+func sortedEntries(sessions map[string]Session) []Entry {
+	var entries []Entry
+	for key, session := range sessions {
+		entries = append(entries, Entry{Key: key, Session: session})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
 }
@@ -1,15 +1,364 @@
 package sessioncache
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/99designs/keyring"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+
+	// use xerrors until 1.13 is stable/oldest supported version
+	"golang.org/x/xerrors"
 )
 
 func TestSingleKrItemStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
 	testStore(t, func() store {
 		return &SingleKrItemStore{
-			Keyring: keyring.NewArrayKeyring([]keyring.Item{}),
+			Keyring:  keyring.NewArrayKeyring([]keyring.Item{}),
+			LockPath: filepath.Join(dir, "session-cache.lock"),
+		}
+	})
+}
+
+func TestSingleKrItemStoreVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newStore := func(data string) *SingleKrItemStore {
+		return &SingleKrItemStore{
+			Keyring: keyring.NewArrayKeyring([]keyring.Item{
+				{Key: KeyringItemKey, Data: []byte(data)},
+			}),
+			LockPath: filepath.Join(dir, "session-cache.lock"),
+		}
+	}
+
+	t.Run("migrate unversioned", func(t *testing.T) {
+		st := newStore(`{"Sessions":{"old":{"Name":"old","Expiration":"2100-01-01T00:00:00Z"}}}`)
+		s, err := st.Get(&fixedKey{"old"})
+		if assert.NoError(t, err) {
+			assert.Equal(t, "old", s.Name)
+		}
+
+		assert.NoError(t, st.Put(&fixedKey{"new"}, &Session{
+			Name:        "new",
+			Credentials: sts.Credentials{Expiration: &theDistantFuture},
+		}))
+		db, err := st.getDb()
+		if assert.NoError(t, err) {
+			assert.Equal(t, singleKrItemDbVersion, db.Version)
+			assert.Len(t, db.Sessions, 2)
+		}
+	})
+
+	t.Run("too new", func(t *testing.T) {
+		st := newStore(`{"Version":1000,"Sessions":{"future":{"Name":"future"}}}`)
+		_, err := st.Get(&fixedKey{"future"})
+		assert.True(t, xerrors.Is(err, ErrDbTooNew), "got %v", err)
+
+		// it isn't downgraded
+		err = st.Put(&fixedKey{"new"}, &Session{
+			Name:        "new",
+			Credentials: sts.Credentials{Expiration: &theDistantFuture},
+		})
+		assert.True(t, xerrors.Is(err, ErrDbTooNew), "got %v", err)
+		item, err := st.Keyring.Get(KeyringItemKey)
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(item.Data), `"Version":1000`)
+			assert.NotContains(t, string(item.Data), `"new"`)
+		}
+	})
+}
+
+func TestSingleKrItemStoreLockTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := &SingleKrItemStore{
+		Keyring:     keyring.NewArrayKeyring([]keyring.Item{}),
+		LockPath:    filepath.Join(dir, "session-cache.lock"),
+		LockTimeout: 50 * time.Millisecond,
+	}
+	key := fixedKey{"locked"}
+	sess := Session{
+		Name:        "locked",
+		Credentials: sts.Credentials{Expiration: &theDistantFuture},
+	}
+	assert.NoError(t, st.Put(&key, &sess))
+
+	// another process holds the lock
+	lock, err := LockFile(st.LockPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	err = st.Put(&fixedKey{"other"}, &sess)
+	assert.True(t, xerrors.Is(err, ErrLockTimeout), "got %v", err)
+	err = st.Delete(&key)
+	assert.True(t, xerrors.Is(err, ErrLockTimeout), "got %v", err)
+
+	// reads go on without the lock
+	_, err = st.Get(&key)
+	assert.NoError(t, err)
+}
+
+// unlockedWriterKeyring writes the db after some of its reads, like a writer
+// that doesn't take the lock
+type unlockedWriterKeyring struct {
+	keyring.Keyring
+	// writes is the number of reads after which the db is written
+	writes int
+	gets   int
+}
+
+func (k *unlockedWriterKeyring) Get(key string) (keyring.Item, error) {
+	item, err := k.Keyring.Get(key)
+	k.gets++
+	// reads come in pairs: before the change, and right before the write
+	if err == nil && k.gets%2 == 1 && k.writes > 0 {
+		k.writes--
+		var db singleKrItemDb
+		if err := json.Unmarshal(item.Data, &db); err != nil {
+			return item, err
+		}
+		db.Sessions[fmt.Sprintf("unlocked-%d", k.writes)] = Session{
+			Name:        "unlocked",
+			Credentials: sts.Credentials{Expiration: &theDistantFuture},
+		}
+		db.Generation++
+		data, err := json.Marshal(db)
+		if err != nil {
+			return item, err
+		}
+		k.Keyring.Set(keyring.Item{Key: key, Data: data})
+	}
+	return item, err
+}
+
+func TestSingleKrItemStoreWriteConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sess := Session{
+		Name:        "locked",
+		Credentials: sts.Credentials{Expiration: &theDistantFuture},
+	}
+	newStore := func(writes int) *SingleKrItemStore {
+		st := &SingleKrItemStore{
+			Keyring:  keyring.NewArrayKeyring([]keyring.Item{}),
+			LockPath: filepath.Join(dir, "session-cache.lock"),
+		}
+		assert.NoError(t, st.Put(&fixedKey{"first"}, &sess))
+		st.Keyring = &unlockedWriterKeyring{Keyring: st.Keyring, writes: writes}
+		return st
+	}
+
+	t.Run("retried", func(t *testing.T) {
+		st := newStore(1)
+		assert.NoError(t, st.Put(&fixedKey{"locked"}, &sess))
+		db, err := st.getDb()
+		if assert.NoError(t, err) {
+			assert.Len(t, db.Sessions, 3)
+			assert.Contains(t, db.Sessions, "unlocked-0")
+			assert.Contains(t, db.Sessions, "locked")
+			assert.Equal(t, int64(3), db.Generation)
+		}
+	})
+
+	t.Run("gave up", func(t *testing.T) {
+		st := newStore(writeAttempts)
+		err := st.Put(&fixedKey{"locked"}, &sess)
+		assert.True(t, xerrors.Is(err, ErrWriteConflict), "got %v", err)
+		db, err := st.getDb()
+		if assert.NoError(t, err) {
+			assert.NotContains(t, db.Sessions, "locked")
+			assert.Len(t, db.Sessions, 1+writeAttempts)
+		}
+	})
+}
+
+func TestSingleKrItemStorePrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := &SingleKrItemStore{
+		Keyring:     keyring.NewArrayKeyring([]keyring.Item{}),
+		LockPath:    filepath.Join(dir, "session-cache.lock"),
+		MaxSessions: 2,
+	}
+	put := func(name string, expiration time.Time) {
+		assert.NoError(t, st.Put(&fixedKey{name}, &Session{
+			Name:        name,
+			Credentials: sts.Credentials{Expiration: &expiration},
+		}))
+	}
+	names := func() []string {
+		entries, err := st.List()
+		assert.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
 		}
+		return names
+	}
+
+	now := time.Now()
+	put("expired", now.Add(-time.Hour))
+	// the session put is kept, even if expired
+	assert.Equal(t, []string{"expired"}, names())
+
+	put("later", now.Add(2*time.Hour))
+	assert.Equal(t, []string{"later"}, names())
+
+	put("sooner", now.Add(time.Hour))
+	put("latest", now.Add(3*time.Hour))
+	// over the cap, the session expiring soonest goes
+	assert.Equal(t, []string{"later", "latest"}, names())
+}
+
+const (
+	hammerProcesses  = 3
+	hammerGoroutines = 4
+	hammerPuts       = 5
+)
+
+// the environment variables of the processes of TestSingleKrItemStoreConcurrency
+const (
+	envHammerDir     = "AWS_OKTA_TEST_HAMMER_DIR"
+	envHammerProcess = "AWS_OKTA_TEST_HAMMER_PROCESS"
+)
+
+func openHammerKeyring(t *testing.T, dir string) keyring.Keyring {
+	kr, err := keyring.Open(keyring.Config{
+		AllowedBackends: []keyring.BackendType{keyring.FileBackend},
+		FileDir:         filepath.Join(dir, "keyring"),
+		FilePasswordFunc: func(string) (string, error) {
+			return "hammer", nil
+		},
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+// hammer puts and gets sessions from several goroutines
+func hammer(t *testing.T, dir, process string) {
+	st := &SingleKrItemStore{
+		Keyring:  openHammerKeyring(t, dir),
+		LockPath: filepath.Join(dir, "session-cache.lock"),
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, hammerGoroutines*hammerPuts)
+	for g := 0; g < hammerGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < hammerPuts; i++ {
+				key := fixedKey{fmt.Sprintf("%s-%d-%d", process, g, i)}
+				sess := Session{
+					Name:        key.v,
+					Credentials: sts.Credentials{Expiration: &theDistantFuture},
+				}
+				if err := st.Put(&key, &sess); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := st.Get(&key); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestSingleKrItemStoreConcurrency checks that sessions put concurrently by
+// several processes, each with several goroutines, are all kept
+func TestSingleKrItemStoreConcurrency(t *testing.T) {
+	if dir := os.Getenv(envHammerDir); dir != "" {
+		// this is one of the processes started below
+		hammer(t, dir, os.Getenv(envHammerProcess))
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "aws-okta-sessioncache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cmds []*exec.Cmd
+	for p := 1; p <= hammerProcesses; p++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSingleKrItemStoreConcurrency$")
+		cmd.Env = append(os.Environ(), envHammerDir+"="+dir, envHammerProcess+"="+strconv.Itoa(p))
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	// and this process too
+	hammer(t, dir, "0")
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("hammering process failed: %s", err)
+		}
+	}
+
+	st := &SingleKrItemStore{
+		Keyring:  openHammerKeyring(t, dir),
+		LockPath: filepath.Join(dir, "session-cache.lock"),
+	}
+	db, err := st.getDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p := 0; p <= hammerProcesses; p++ {
+		for g := 0; g < hammerGoroutines; g++ {
+			for i := 0; i < hammerPuts; i++ {
+				key := fmt.Sprintf("%d-%d-%d", p, g, i)
+				if _, ok := db.Sessions[key]; !ok {
+					t.Errorf("session %s was lost", key)
+				}
+			}
+		}
+	}
+	if expected := int64((hammerProcesses + 1) * hammerGoroutines * hammerPuts); db.Generation != expected {
+		t.Errorf("expected generation %d, got %d", expected, db.Generation)
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/99designs/keyring"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/xerrors"
@@ -13,6 +14,8 @@ import (
 type store interface {
 	Get(Key) (*Session, error)
 	Put(Key, *Session) error
+	List() ([]Entry, error)
+	Delete(Key) error
 }
 
 var theDistantFuture = time.Date(3000, 0, 0, 0, 0, 0, 0, time.UTC)
@@ -73,4 +76,58 @@ func testStore(t *testing.T, storeFactory func() store) {
 			t.Fatalf("expected get err to be ErrSessionExpired; is %s", err)
 		}
 	})
+
+	tName = "list-delete"
+	t.Run(tName, func(t *testing.T) {
+		st := storeFactory()
+
+		entries, err := st.List()
+		if err != nil {
+			t.Fatalf("error on list: %s", err)
+		}
+		assert.Empty(t, entries)
+
+		// keys look like real ones, which some stores rely on to tell
+		// sessions apart from other items
+		live := fixedKey{"live session (0123456789)"}
+		expired := fixedKey{"expired session (abcdef0123)"}
+		liveSess := Session{
+			Name:        "live",
+			Credentials: sts.Credentials{Expiration: &theDistantFuture},
+		}
+		expiredSess := Session{
+			Name:        "expired",
+			Credentials: sts.Credentials{Expiration: &theDistantPast},
+		}
+		if err := st.Put(&live, &liveSess); err != nil {
+			t.Fatalf("error on put: %s", err)
+		}
+		if err := st.Put(&expired, &expiredSess); err != nil {
+			t.Fatalf("error on put: %s", err)
+		}
+
+		// expired sessions are listed too
+		entries, err = st.List()
+		if err != nil {
+			t.Fatalf("error on list: %s", err)
+		}
+		assert.Equal(t, []Entry{
+			{Key: expired.v, Session: expiredSess},
+			{Key: live.v, Session: liveSess},
+		}, entries)
+
+		if err := st.Delete(RawKey(expired.v)); err != nil {
+			t.Fatalf("error on delete: %s", err)
+		}
+		entries, err = st.List()
+		if err != nil {
+			t.Fatalf("error on list: %s", err)
+		}
+		assert.Equal(t, []Entry{{Key: live.v, Session: liveSess}}, entries)
+
+		err = st.Delete(&expired)
+		if !xerrors.Is(err, keyring.ErrKeyNotFound) {
+			t.Fatalf("expected delete err to be keyring.ErrKeyNotFound; is %s", err)
+		}
+	})
 }